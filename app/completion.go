@@ -0,0 +1,80 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alekLukanen/errs"
+)
+
+// CompletionProgress is how much of a table's inserted data the
+// warehouse reflects.
+type CompletionProgress struct {
+	Pending int64
+	Total   int64
+}
+
+func (obj CompletionProgress) Done() bool {
+	return obj.Pending == 0
+}
+
+// CompletionCheck measures the progress of a table once.
+type CompletionCheck func(ctx context.Context) (CompletionProgress, error)
+
+/*
+Polls check every pollInterval until it reports nothing pending,
+logging the progress as it goes. A failed check is logged and tried
+again on the next poll since the table state may not exist until the
+first batch was processed. Returns ErrCompletionTimeout, with the last
+progress, when timeout passes first.
+*/
+func WaitForCompletion(
+	ctx context.Context,
+	logger *slog.Logger,
+	tableName string,
+	timeout time.Duration,
+	pollInterval time.Duration,
+	check CompletionCheck,
+) error {
+	start := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var progress CompletionProgress
+	checked := false
+	for {
+		current, err := check(ctx)
+		if err != nil {
+			logger.Warn("unable to check the table completion", slog.String("table", tableName), slog.String("error", err.Error()))
+		} else {
+			progress, checked = current, true
+			logger.Info(
+				"waiting for the table to complete",
+				slog.String("table", tableName),
+				slog.Int64("pending", progress.Pending),
+				slog.Int64("total", progress.Total),
+				slog.Duration("elapsed", time.Since(start)),
+			)
+			if progress.Done() {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			if !checked {
+				return errs.NewStackError(fmt.Errorf("%w| table %s was never checked in %s", ErrCompletionTimeout, tableName, timeout))
+			}
+			return errs.NewStackError(
+				fmt.Errorf("%w| table %s has %d of %d keys pending after %s", ErrCompletionTimeout, tableName, progress.Pending, progress.Total, timeout),
+			)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alekLukanen/ChapterhouseDB-v1/storage"
+	"github.com/alekLukanen/ChapterhouseDB-v1/tasker"
+	"github.com/alekLukanen/errs"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigPathEnvVar is read by the commands when no -config flag is given.
+const ConfigPathEnvVar = "CHDB_CONFIG"
+
+type Config struct {
+	WarehouseName string              `yaml:"warehouseName" toml:"warehouseName"`
+	ObjectStorage ObjectStorageConfig `yaml:"objectStorage" toml:"objectStorage"`
+	KeyStorage    KeyStorageConfig    `yaml:"keyStorage" toml:"keyStorage"`
+	Manifest      ManifestConfig      `yaml:"manifest" toml:"manifest"`
+	Tasker        TaskerConfig        `yaml:"tasker" toml:"tasker"`
+	Worker        WorkerConfig        `yaml:"worker" toml:"worker"`
+	Health        HealthConfig        `yaml:"health" toml:"health"`
+	Metrics       MetricsConfig       `yaml:"metrics" toml:"metrics"`
+
+	// optional directory of YAML/JSON table specs which are
+	// registered next to the tables defined in Go
+	TableSpecDir string `yaml:"tableSpecDir" toml:"tableSpecDir"`
+	// optional directory of YAML/JSON dataset specs which the
+	// tester registers as dataset generators
+	DatasetSpecDir string `yaml:"datasetSpecDir" toml:"datasetSpecDir"`
+}
+
+type ObjectStorageConfig struct {
+	Endpoint     string `yaml:"endpoint" toml:"endpoint"`
+	Region       string `yaml:"region" toml:"region"`
+	AuthKey      string `yaml:"authKey" toml:"authKey"`
+	AuthSecret   string `yaml:"authSecret" toml:"authSecret"`
+	UsePathStyle bool   `yaml:"usePathStyle" toml:"usePathStyle"`
+
+	// host:port DuckDB uses to reach the object storage. This is
+	// not always the same as the endpoint, since the tester may
+	// reach MinIO through a node port.
+	QueryEndpoint string `yaml:"queryEndpoint" toml:"queryEndpoint"`
+}
+
+type KeyStorageConfig struct {
+	Address   string `yaml:"address" toml:"address"`
+	Password  string `yaml:"password" toml:"password"`
+	KeyPrefix string `yaml:"keyPrefix" toml:"keyPrefix"`
+}
+
+type ManifestConfig struct {
+	BucketName string `yaml:"bucketName" toml:"bucketName"`
+	KeyPrefix  string `yaml:"keyPrefix" toml:"keyPrefix"`
+}
+
+type TaskerConfig struct {
+	TaskTimeout time.Duration `yaml:"taskTimeout" toml:"taskTimeout"`
+}
+
+type WorkerConfig struct {
+	// how long the worker waits for in-progress tasks to finish
+	// after receiving SIGINT or SIGTERM
+	DrainTimeout time.Duration `yaml:"drainTimeout" toml:"drainTimeout"`
+}
+
+type HealthConfig struct {
+	// address of the worker's health HTTP server, for example ":8080";
+	// the server is not started when this is empty
+	Address string `yaml:"address" toml:"address"`
+
+	CheckTimeout time.Duration `yaml:"checkTimeout" toml:"checkTimeout"`
+	// how long a step of the run loop, claiming and processing one
+	// task or polling for one, may take before the worker is not live;
+	// it must be longer than tasker.taskTimeout
+	HeartbeatTimeout time.Duration `yaml:"heartbeatTimeout" toml:"heartbeatTimeout"`
+}
+
+type MetricsConfig struct {
+	// address of the Prometheus /metrics HTTP server, for example ":9090";
+	// the server is not started when this is empty
+	Address string `yaml:"address" toml:"address"`
+
+	QueueSampleInterval time.Duration `yaml:"queueSampleInterval" toml:"queueSampleInterval"`
+}
+
+// DefaultConfig returns the config used by the in-cluster deployment.
+func DefaultConfig() *Config {
+	return &Config{
+		WarehouseName: "warehouse1",
+		ObjectStorage: ObjectStorageConfig{
+			Endpoint:      "http://chdb-minio-api:9000",
+			Region:        "us-west-2",
+			AuthKey:       "minioadmin",
+			AuthSecret:    "minioadmin",
+			UsePathStyle:  true,
+			QueryEndpoint: "pi0:30006",
+		},
+		KeyStorage: KeyStorageConfig{
+			Address:   "chdb-keydb:6379",
+			Password:  "",
+			KeyPrefix: "chapterhouseDB",
+		},
+		Manifest: ManifestConfig{
+			BucketName: "chdb-test-warehouse",
+			KeyPrefix:  "chdb",
+		},
+		Tasker: TaskerConfig{
+			TaskTimeout: 1 * time.Minute,
+		},
+		Worker: WorkerConfig{
+			DrainTimeout: 30 * time.Second,
+		},
+		Health: HealthConfig{
+			Address:          "",
+			CheckTimeout:     2 * time.Second,
+			HeartbeatTimeout: 90 * time.Second,
+		},
+		Metrics: MetricsConfig{
+			Address:             "",
+			QueueSampleInterval: 5 * time.Second,
+		},
+	}
+}
+
+/*
+Loads the config by starting from the defaults, then applying
+the file at path (if not empty) and finally the CHDB_* environment
+variable overrides. The result is validated before it is returned.
+Supported file types are .yaml, .yml and .toml.
+*/
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		err := cfg.decodeFile(path)
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("failed to decode config file: %s", path))
+		}
+	}
+
+	err := cfg.applyEnv(os.LookupEnv)
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("failed to apply environment overrides"))
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (obj *Config) decodeFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+
+	// unknown keys are errors so a misspelled setting doesn't silently
+	// keep its default
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(obj)
+		// an empty file sets nothing
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), obj)
+		if err == nil && len(md.Undecoded()) > 0 {
+			keys := make([]string, len(md.Undecoded()))
+			for i, key := range md.Undecoded() {
+				keys[i] = key.String()
+			}
+			err = fmt.Errorf("unknown keys %s", strings.Join(keys, ", "))
+		}
+	default:
+		return errs.NewStackError(fmt.Errorf("%w| file: %s", ErrUnsupportedConfigType, path))
+	}
+	if err != nil {
+		return errs.NewStackError(fmt.Errorf("%w| %s: %s", ErrInvalidConfig, path, err))
+	}
+
+	return nil
+}
+
+func (obj *Config) applyEnv(lookup func(string) (string, bool)) error {
+	strVars := []struct {
+		name  string
+		value *string
+	}{
+		{"CHDB_WAREHOUSE_NAME", &obj.WarehouseName},
+		{"CHDB_OBJECT_STORAGE_ENDPOINT", &obj.ObjectStorage.Endpoint},
+		{"CHDB_OBJECT_STORAGE_REGION", &obj.ObjectStorage.Region},
+		{"CHDB_OBJECT_STORAGE_AUTH_KEY", &obj.ObjectStorage.AuthKey},
+		{"CHDB_OBJECT_STORAGE_AUTH_SECRET", &obj.ObjectStorage.AuthSecret},
+		{"CHDB_OBJECT_STORAGE_QUERY_ENDPOINT", &obj.ObjectStorage.QueryEndpoint},
+		{"CHDB_KEY_STORAGE_ADDRESS", &obj.KeyStorage.Address},
+		{"CHDB_KEY_STORAGE_PASSWORD", &obj.KeyStorage.Password},
+		{"CHDB_KEY_STORAGE_KEY_PREFIX", &obj.KeyStorage.KeyPrefix},
+		{"CHDB_MANIFEST_BUCKET_NAME", &obj.Manifest.BucketName},
+		{"CHDB_MANIFEST_KEY_PREFIX", &obj.Manifest.KeyPrefix},
+		{"CHDB_TABLE_SPEC_DIR", &obj.TableSpecDir},
+		{"CHDB_DATASET_SPEC_DIR", &obj.DatasetSpecDir},
+		{"CHDB_HEALTH_ADDRESS", &obj.Health.Address},
+		{"CHDB_METRICS_ADDRESS", &obj.Metrics.Address},
+	}
+	for _, v := range strVars {
+		if val, ok := lookup(v.name); ok {
+			*v.value = val
+		}
+	}
+
+	if val, ok := lookup("CHDB_OBJECT_STORAGE_USE_PATH_STYLE"); ok {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return errs.NewStackError(
+				fmt.Errorf("%w| CHDB_OBJECT_STORAGE_USE_PATH_STYLE: %s", ErrInvalidConfig, err),
+			)
+		}
+		obj.ObjectStorage.UsePathStyle = b
+	}
+
+	durationVars := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"CHDB_TASKER_TASK_TIMEOUT", &obj.Tasker.TaskTimeout},
+		{"CHDB_WORKER_DRAIN_TIMEOUT", &obj.Worker.DrainTimeout},
+		{"CHDB_HEALTH_CHECK_TIMEOUT", &obj.Health.CheckTimeout},
+		{"CHDB_HEALTH_HEARTBEAT_TIMEOUT", &obj.Health.HeartbeatTimeout},
+		{"CHDB_METRICS_QUEUE_SAMPLE_INTERVAL", &obj.Metrics.QueueSampleInterval},
+	}
+	for _, v := range durationVars {
+		if val, ok := lookup(v.name); ok {
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return errs.NewStackError(
+					fmt.Errorf("%w| %s: %s", ErrInvalidConfig, v.name, err),
+				)
+			}
+			*v.value = d
+		}
+	}
+
+	return nil
+}
+
+// Validate reports every invalid field by its config file path.
+func (obj *Config) Validate() error {
+	problems := make([]string, 0)
+	required := []struct {
+		field string
+		value string
+	}{
+		{"warehouseName", obj.WarehouseName},
+		{"objectStorage.endpoint", obj.ObjectStorage.Endpoint},
+		{"objectStorage.region", obj.ObjectStorage.Region},
+		{"objectStorage.authKey", obj.ObjectStorage.AuthKey},
+		{"objectStorage.authSecret", obj.ObjectStorage.AuthSecret},
+		{"keyStorage.address", obj.KeyStorage.Address},
+		{"keyStorage.keyPrefix", obj.KeyStorage.KeyPrefix},
+		{"manifest.bucketName", obj.Manifest.BucketName},
+		{"manifest.keyPrefix", obj.Manifest.KeyPrefix},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			problems = append(problems, fmt.Sprintf("%s must not be empty", r.field))
+		}
+	}
+
+	if obj.ObjectStorage.Endpoint != "" &&
+		!strings.HasPrefix(obj.ObjectStorage.Endpoint, "http://") &&
+		!strings.HasPrefix(obj.ObjectStorage.Endpoint, "https://") {
+		problems = append(problems, "objectStorage.endpoint must start with http:// or https://")
+	}
+	if strings.Contains(obj.ObjectStorage.QueryEndpoint, "://") {
+		problems = append(problems, "objectStorage.queryEndpoint must be host:port without a scheme")
+	}
+	if obj.Tasker.TaskTimeout <= 0 {
+		problems = append(problems, "tasker.taskTimeout must be greater than zero")
+	}
+	if obj.Worker.DrainTimeout <= 0 {
+		problems = append(problems, "worker.drainTimeout must be greater than zero")
+	}
+	if obj.Health.CheckTimeout <= 0 {
+		problems = append(problems, "health.checkTimeout must be greater than zero")
+	}
+	if obj.Health.HeartbeatTimeout <= 0 {
+		problems = append(problems, "health.heartbeatTimeout must be greater than zero")
+	}
+	if obj.Health.HeartbeatTimeout <= obj.Tasker.TaskTimeout {
+		problems = append(problems, "health.heartbeatTimeout must be greater than tasker.taskTimeout")
+	}
+	if obj.Metrics.QueueSampleInterval <= 0 {
+		problems = append(problems, "metrics.queueSampleInterval must be greater than zero")
+	}
+	if obj.Health.HeartbeatTimeout <= obj.Metrics.QueueSampleInterval {
+		problems = append(problems, "health.heartbeatTimeout must be greater than metrics.queueSampleInterval")
+	}
+	if obj.Metrics.Address != "" && obj.Metrics.Address == obj.Health.Address {
+		problems = append(problems, "metrics.address must differ from health.address")
+	}
+	if obj.TableSpecDir != "" {
+		if info, err := os.Stat(obj.TableSpecDir); err != nil || !info.IsDir() {
+			problems = append(problems, "tableSpecDir must be an existing directory")
+		}
+	}
+	if obj.DatasetSpecDir != "" {
+		if info, err := os.Stat(obj.DatasetSpecDir); err != nil || !info.IsDir() {
+			problems = append(problems, "datasetSpecDir must be an existing directory")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errs.NewStackError(
+			fmt.Errorf("%w| %s", ErrInvalidConfig, strings.Join(problems, "; ")),
+		)
+	}
+	return nil
+}
+
+func (obj *Config) ObjectStorageOptions() storage.ObjectStorageOptions {
+	return storage.ObjectStorageOptions{
+		Endpoint:     obj.ObjectStorage.Endpoint,
+		Region:       obj.ObjectStorage.Region,
+		AuthKey:      obj.ObjectStorage.AuthKey,
+		AuthSecret:   obj.ObjectStorage.AuthSecret,
+		UsePathStyle: obj.ObjectStorage.UsePathStyle,
+		AuthType:     storage.ObjectStorageAuthTypeStatic,
+	}
+}
+
+func (obj *Config) KeyStorageOptions() storage.KeyStorageOptions {
+	return storage.KeyStorageOptions{
+		Address:   obj.KeyStorage.Address,
+		Password:  obj.KeyStorage.Password,
+		KeyPrefix: obj.KeyStorage.KeyPrefix,
+	}
+}
+
+func (obj *Config) ManifestStorageOptions() storage.ManifestStorageOptions {
+	return storage.ManifestStorageOptions{
+		BucketName: obj.Manifest.BucketName,
+		KeyPrefix:  obj.Manifest.KeyPrefix,
+	}
+}
+
+func (obj *Config) TaskerOptions() tasker.Options {
+	return tasker.Options{
+		KeyDBAddress:  obj.KeyStorage.Address,
+		KeyDBPassword: obj.KeyStorage.Password,
+		KeyPrefix:     obj.KeyStorage.KeyPrefix,
+		TaskTimeout:   obj.Tasker.TaskTimeout,
+	}
+}
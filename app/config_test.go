@@ -0,0 +1,55 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigExample(t *testing.T) {
+	cfg, err := LoadConfig(filepath.Join("..", "configs", "local.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.WarehouseName != "warehouse1" || cfg.Worker.DrainTimeout != 30*time.Second {
+		t.Fatalf("loaded %+v", cfg)
+	}
+}
+
+func TestLoadConfigFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		err  error
+	}{
+		{"yaml", "config.yaml", "warehouseName: w2\n", nil},
+		{"empty yaml", "config.yaml", "", nil},
+		{"toml", "config.toml", "warehouseName = \"w2\"\n[worker]\ndrainTimeout = \"10s\"\n", nil},
+		{"misspelled yaml key", "config.yaml", "warehouseNmae: w2\n", ErrInvalidConfig},
+		{"misspelled nested yaml key", "config.yml", "worker:\n  drainTimout: 10s\n", ErrInvalidConfig},
+		{"misspelled toml key", "config.toml", "warehouseNmae = \"w2\"\n", ErrInvalidConfig},
+		{"misspelled nested toml key", "config.toml", "[worker]\ndrainTimout = \"10s\"\n", ErrInvalidConfig},
+		{"unsupported type", "config.json", "{}", ErrUnsupportedConfigType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			err := os.WriteFile(path, []byte(tt.data), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = LoadConfig(path)
+			if tt.err == nil && err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
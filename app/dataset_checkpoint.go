@@ -0,0 +1,147 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/alekLukanen/errs"
+)
+
+/*
+The position of a dataset: how many records and rows it produced,
+the state of its random generator, how far each unique column has
+walked its id permutation. Schema is the fingerprint of the
+dataset's schema so that a checkpoint is not restored into a
+different dataset. An update workload only reuses keys within a
+record, so it has no state between records to save.
+*/
+type DatasetCheckpoint struct {
+	Schema              string            `json:"schema"`
+	Seed                uint64            `json:"seed"`
+	RowsPerRecord       int               `json:"rowsPerRecord"`
+	IterationsCompleted int               `json:"iterationsCompleted"`
+	RowsGenerated       int               `json:"rowsGenerated"`
+	RandState           []byte            `json:"randState"`
+	IdPositions         map[string]uint64 `json:"idPositions"`
+}
+
+func (obj *GenericDataset) Checkpoint() (*DatasetCheckpoint, error) {
+	randState, err := obj.randSource.MarshalBinary()
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+
+	idPositions := make(map[string]uint64, len(obj.uniqueIds))
+	for name, gen := range obj.uniqueIds {
+		idPositions[name] = gen.ids.Position()
+	}
+
+	checkpoint := &DatasetCheckpoint{
+		Schema:              obj.schema.Fingerprint(),
+		Seed:                obj.seed,
+		RowsPerRecord:       obj.rowsPerRecord,
+		IterationsCompleted: obj.iterationsCompleted,
+		RowsGenerated:       obj.rowsGenerated,
+		RandState:           randState,
+		IdPositions:         idPositions,
+	}
+	return checkpoint, nil
+}
+
+func (obj *GenericDataset) Restore(checkpoint *DatasetCheckpoint) error {
+	switch {
+	case checkpoint.Schema != obj.schema.Fingerprint():
+		return errs.NewStackError(fmt.Errorf("%w| the checkpoint is for a different schema", ErrInvalidCheckpoint))
+	case checkpoint.Seed != obj.seed:
+		return errs.NewStackError(
+			fmt.Errorf("%w| the checkpoint seed %d does not match the dataset seed %d", ErrInvalidCheckpoint, checkpoint.Seed, obj.seed),
+		)
+	case checkpoint.RowsPerRecord != obj.rowsPerRecord:
+		return errs.NewStackError(
+			fmt.Errorf(
+				"%w| the checkpoint has %d rows per record, the dataset %d",
+				ErrInvalidCheckpoint, checkpoint.RowsPerRecord, obj.rowsPerRecord,
+			),
+		)
+	}
+	for name := range checkpoint.IdPositions {
+		if _, ok := obj.uniqueIds[name]; !ok {
+			return errs.NewStackError(fmt.Errorf("%w| column %q has no unique generator", ErrInvalidCheckpoint, name))
+		}
+	}
+	for name, gen := range obj.uniqueIds {
+		if checkpoint.IdPositions[name] > gen.ids.Size() {
+			return errs.NewStackError(fmt.Errorf("%w| column %q is past the end of its id range", ErrInvalidCheckpoint, name))
+		}
+	}
+
+	err := obj.randSource.UnmarshalBinary(checkpoint.RandState)
+	if err != nil {
+		return errs.NewStackError(fmt.Errorf("%w| %s", ErrInvalidCheckpoint, err))
+	}
+
+	for name, gen := range obj.uniqueIds {
+		err = gen.ids.SetPosition(checkpoint.IdPositions[name])
+		if err != nil {
+			return err
+		}
+	}
+	obj.iterationsCompleted = checkpoint.IterationsCompleted
+	obj.rowsGenerated = checkpoint.RowsGenerated
+
+	return nil
+}
+
+/*
+Writes the checkpoint as JSON. The file is written next to path and
+renamed over it so that a crash never leaves a partial checkpoint.
+*/
+func SaveDatasetCheckpoint(path string, checkpoint *DatasetCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		tmpFile.Close()
+		return errs.NewStackError(err)
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+	return nil
+}
+
+// LoadDatasetCheckpoint reads a checkpoint written by SaveDatasetCheckpoint.
+// A missing file returns a nil checkpoint and no error.
+func LoadDatasetCheckpoint(path string) (*DatasetCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+
+	checkpoint := &DatasetCheckpoint{}
+	err = json.Unmarshal(data, checkpoint)
+	if err != nil {
+		return nil, errs.NewStackError(fmt.Errorf("%w| %s: %s", ErrInvalidCheckpoint, path, err))
+	}
+	return checkpoint, nil
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/errs"
+)
+
+type DatasetParams struct {
+	RowsPerRecord int
+	MaxIdValue    int
+	MaxIterations int
+	Seed          uint64
+
+	// the table being filled and, optionally, the subscription source;
+	// generators which derive their columns from the table use these
+	Table      *elements.Table
+	SourceName string
+
+	// files or globs read by the file replay dataset
+	Files []string
+
+	Workload UpdateWorkload
+}
+
+// DefaultDatasetParams matches the medium sized random datasets.
+func DefaultDatasetParams() DatasetParams {
+	return DatasetParams{
+		RowsPerRecord: 1000,
+		MaxIdValue:    100_000,
+		MaxIterations: 10,
+		Seed:          64,
+	}
+}
+
+func (obj DatasetParams) Validate() error {
+	if obj.RowsPerRecord <= 0 {
+		return errs.NewStackError(fmt.Errorf("%w| rowsPerRecord must be greater than zero", ErrInvalidDatasetParams))
+	}
+	if obj.MaxIdValue <= 0 {
+		return errs.NewStackError(fmt.Errorf("%w| maxIdValue must be greater than zero", ErrInvalidDatasetParams))
+	}
+	if obj.MaxIterations <= 0 {
+		return errs.NewStackError(fmt.Errorf("%w| maxIterations must be greater than zero", ErrInvalidDatasetParams))
+	}
+	return obj.Workload.Validate()
+}
+
+type DatasetFactory func(params DatasetParams) (StreamingDataset, error)
+
+var (
+	datasetFactoriesMu sync.RWMutex
+	datasetFactories   = make(map[string]DatasetFactory)
+)
+
+/*
+Registers a dataset generator under name. Generators register
+themselves from an init function in their own file so that adding
+a generator does not require changes anywhere else.
+*/
+func RegisterDataset(name string, factory DatasetFactory) {
+	err := registerDataset(name, factory)
+	if err != nil {
+		panic(errs.ErrorWithStack(err))
+	}
+}
+
+func registerDataset(name string, factory DatasetFactory) error {
+	datasetFactoriesMu.Lock()
+	defer datasetFactoriesMu.Unlock()
+
+	if _, ok := datasetFactories[name]; ok {
+		return errs.NewStackError(fmt.Errorf("%w| dataset %q", ErrDuplicateDataset, name))
+	}
+	datasetFactories[name] = factory
+	return nil
+}
+
+func NewDataset(name string, params DatasetParams) (StreamingDataset, error) {
+	datasetFactoriesMu.RLock()
+	factory, ok := datasetFactories[name]
+	datasetFactoriesMu.RUnlock()
+	if !ok {
+		return nil, errs.NewStackError(
+			fmt.Errorf("%w| dataset %q; registered datasets: %v", ErrDatasetNotFound, name, DatasetNames()),
+		)
+	}
+
+	err := params.Validate()
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("dataset %q", name))
+	}
+
+	return factory(params)
+}
+
+func DatasetNames() []string {
+	datasetFactoriesMu.RLock()
+	defer datasetFactoriesMu.RUnlock()
+
+	names := make([]string, 0, len(datasetFactories))
+	for name := range datasetFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/alekLukanen/errs"
+)
+
+/*
+DatasetSpec is the file representation of a GenericDataset for one
+table, for example
+
+	name: random-table3
+	table: table3
+	source: sourceSystemTable3
+	columns:
+	  - {name: column1, generator: unique, max: 50000}
+	  - {name: column2, generator: categorical, values: ["true", "false"]}
+	  - {name: column3, generator: normal, mean: 100, stdDev: 15, nullRatio: 0.05}
+
+The columns come from the table's subscription for source (or its first
+subscription when source is empty); columns which are not listed use the
+default generator for their type. The spec is registered as a dataset
+under its name.
+*/
+type DatasetSpec struct {
+	Name    string                `yaml:"name" json:"name"`
+	Table   string                `yaml:"table" json:"table"`
+	Source  string                `yaml:"source" json:"source"`
+	Columns []ColumnGeneratorSpec `yaml:"columns" json:"columns"`
+}
+
+// LoadDatasetSpecs reads every .yaml, .yml and .json file in dir as a DatasetSpec.
+func LoadDatasetSpecs(dir string) ([]*DatasetSpec, error) {
+	fileNames, err := specFileNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make([]*DatasetSpec, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		fp := filepath.Join(dir, fileName)
+		spec, err := LoadDatasetSpecFile(fp)
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("failed to load dataset spec: %s", fp))
+		}
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+func LoadDatasetSpecFile(path string) (*DatasetSpec, error) {
+	spec := &DatasetSpec{}
+	err := decodeSpecFile(path, spec, ErrInvalidDatasetSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	err = spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+/*
+Validate reports the problems which can be found without the table.
+Generator settings are checked against the column types when the
+dataset is built.
+*/
+func (obj *DatasetSpec) Validate() error {
+	problems := make([]string, 0)
+	addProblem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if strings.TrimSpace(obj.Name) == "" {
+		addProblem("name must not be empty")
+	}
+	if strings.TrimSpace(obj.Table) == "" {
+		addProblem("table must not be empty")
+	}
+
+	names := make(map[string]struct{}, len(obj.Columns))
+	for i, col := range obj.Columns {
+		field := fmt.Sprintf("columns[%d]", i)
+		if strings.TrimSpace(col.Name) == "" {
+			addProblem("%s.name must not be empty", field)
+			continue
+		}
+		if _, ok := names[col.Name]; ok {
+			addProblem("%s.name %q is duplicated", field, col.Name)
+		}
+		names[col.Name] = struct{}{}
+		if strings.TrimSpace(col.Generator) == "" {
+			addProblem("%s.generator must not be empty", field)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errs.NewStackError(
+			fmt.Errorf("%w| dataset %q: %s", ErrInvalidDatasetSpec, obj.Name, strings.Join(problems, "; ")),
+		)
+	}
+	return nil
+}
+
+/*
+Registers the spec as a dataset. The factory requires
+DatasetParams.Table to be the table named by the spec.
+*/
+func (obj *DatasetSpec) Register() error {
+	spec := *obj
+	return registerDataset(spec.Name, func(params DatasetParams) (StreamingDataset, error) {
+		if params.Table == nil || params.Table.TableName() != spec.Table {
+			return nil, errs.NewStackError(
+				fmt.Errorf("%w| dataset %q generates rows for table %q", ErrInvalidDatasetParams, spec.Name, spec.Table),
+			)
+		}
+		columns, err := subscriptionColumns(params.Table, spec.Source)
+		if err != nil {
+			return nil, err
+		}
+		return NewGenericDataset(columns, spec.Columns, params)
+	})
+}
+
+// RegisterDatasetSpecs loads the dataset specs in dir and registers each one.
+func RegisterDatasetSpecs(dir string) ([]string, error) {
+	specs, err := LoadDatasetSpecs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		err := spec.Register()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, spec.Name)
+	}
+	return names, nil
+}
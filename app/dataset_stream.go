@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"io"
+
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+/*
+Returns the dataset as a StreamingDataset. Datasets which already
+implement the interface are returned as is; any other dataset is
+wrapped by an adapter.
+*/
+func StreamDataset(dataset Dataset) StreamingDataset {
+	if stream, ok := dataset.(StreamingDataset); ok {
+		return stream
+	}
+	return &datasetStream{
+		dataset:  dataset,
+		buildMem: memory.NewGoAllocator(),
+	}
+}
+
+/*
+Adapts a Dataset to the StreamingDataset interface. A Dataset
+always builds its records with a *memory.GoAllocator, so the adapter
+builds each record with its own and, when the caller passes another
+kind of allocator such as a checked one, copies the record into it.
+The schema is only known once a record was built, so Schema builds
+the first record early and Next hands it out later. A dataset which
+is done before its first record has a schema without fields.
+*/
+type datasetStream struct {
+	dataset  Dataset
+	buildMem *memory.GoAllocator
+
+	schema  *arrow.Schema
+	pending arrow.Record
+}
+
+func (obj *datasetStream) Schema() *arrow.Schema {
+	if obj.schema == nil && obj.pending == nil && !obj.dataset.Done() {
+		obj.pending = obj.dataset.BuildRecord(obj.buildMem)
+		obj.schema = obj.pending.Schema()
+	}
+	if obj.schema == nil {
+		return emptySchema
+	}
+	return obj.schema
+}
+
+var emptySchema = arrow.NewSchema(nil, nil)
+
+func (obj *datasetStream) Next(ctx context.Context, mem memory.Allocator) (arrow.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rec := obj.pending
+	obj.pending = nil
+	if rec == nil {
+		if obj.dataset.Done() {
+			return nil, io.EOF
+		}
+		rec = obj.dataset.BuildRecord(obj.buildMem)
+	}
+	obj.schema = rec.Schema()
+
+	// Go allocators hand out garbage collected memory, so a record from
+	// any of them can be returned without a copy
+	if _, ok := mem.(*memory.GoAllocator); ok {
+		return rec, nil
+	}
+	defer rec.Release()
+	return copyRecord(mem, rec)
+}
+
+func (obj *datasetStream) Close() error {
+	if obj.pending != nil {
+		obj.pending.Release()
+		obj.pending = nil
+	}
+	return nil
+}
+
+// copyRecord copies every column of the record into buffers allocated from mem.
+func copyRecord(mem memory.Allocator, rec arrow.Record) (arrow.Record, error) {
+	columns := make([]arrow.Array, rec.NumCols())
+	defer func() {
+		for _, col := range columns {
+			if col != nil {
+				col.Release()
+			}
+		}
+	}()
+
+	for i, col := range rec.Columns() {
+		copied, err := array.Concatenate([]arrow.Array{col}, mem)
+		if err != nil {
+			return nil, errs.NewStackError(err)
+		}
+		columns[i] = copied
+	}
+
+	return array.NewRecord(rec.Schema(), columns, rec.NumRows()), nil
+}
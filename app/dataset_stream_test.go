@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// countDataset is a Dataset which only implements BuildRecord, so that
+// StreamDataset has to wrap it.
+type countDataset struct {
+	records int
+	built   int
+}
+
+func (obj *countDataset) Done() bool {
+	return obj.built >= obj.records
+}
+
+func (obj *countDataset) BuildRecord(mem *memory.GoAllocator) arrow.Record {
+	schema := arrow.NewSchema([]arrow.Field{{Name: "n", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	builder := array.NewRecordBuilder(mem, schema)
+	defer builder.Release()
+	builder.Field(0).(*array.Int64Builder).AppendValues([]int64{int64(obj.built), int64(obj.built) + 1}, nil)
+	obj.built++
+	return builder.NewRecord()
+}
+
+func TestStreamDataset(t *testing.T) {
+	tests := []struct {
+		name    string
+		records int
+		fields  int
+	}{
+		{"empty", 0, 0},
+		{"one record", 1, 1},
+		{"several records", 3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+			defer mem.AssertSize(t, 0)
+
+			stream := StreamDataset(&countDataset{records: tt.records})
+			defer stream.Close()
+
+			schema := stream.Schema()
+			if schema == nil || schema.NumFields() != tt.fields {
+				t.Fatalf("Schema() = %v, want %d fields", schema, tt.fields)
+			}
+
+			for i := 0; i < tt.records; i++ {
+				record, err := stream.Next(context.Background(), mem)
+				if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				if got := record.Column(0).(*array.Int64).Value(0); got != int64(i) {
+					t.Errorf("record %d starts with %d", i, got)
+				}
+				record.Release()
+			}
+
+			_, err := stream.Next(context.Background(), mem)
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("got %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestStreamDatasetReturnsStreamingDatasets(t *testing.T) {
+	dataset, err := NewRandomTable1Dataset(DefaultDatasetParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream := StreamDataset(dataset); stream != StreamingDataset(dataset) {
+		t.Fatal("StreamDataset wrapped a StreamingDataset")
+	}
+}
+
+func TestStreamDatasetCancelled(t *testing.T) {
+	stream := StreamDataset(&countDataset{records: 1})
+	defer stream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := stream.Next(ctx, memory.NewGoAllocator())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
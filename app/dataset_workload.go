@@ -0,0 +1,128 @@
+package app
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+/*
+Makes a dataset re-emit keys within a record so that the
+deduplication of the table's pipeline is exercised. A share of the
+rows (InRecordUpdateRatio) update a key emitted earlier in the same
+record; the rest get new keys.
+
+Updates stay within a record because the pipelines only order the
+versions of a key within the batch they transform: VersionColumn is
+not written to the table, so a version sent in a later record can't
+be compared with the one already written. The key is the dataset's
+unique column and the version is VersionColumn, which must use the
+sequence generator so that later rows are newer. The other columns
+are generated for the row as usual, so every version of a key
+carries different values.
+*/
+type UpdateWorkload struct {
+	InRecordUpdateRatio float64
+	VersionColumn       string
+}
+
+func (obj UpdateWorkload) Enabled() bool {
+	return obj.InRecordUpdateRatio > 0
+}
+
+func (obj UpdateWorkload) Validate() error {
+	if obj.InRecordUpdateRatio < 0 || obj.InRecordUpdateRatio > 1 {
+		return errs.NewStackError(fmt.Errorf("%w| inRecordUpdateRatio must be between 0 and 1", ErrInvalidDatasetParams))
+	}
+	if obj.Enabled() && obj.VersionColumn == "" {
+		return errs.NewStackError(fmt.Errorf("%w| the update workload requires a version column", ErrInvalidDatasetParams))
+	}
+	return nil
+}
+
+// rowPlan says which key a row is generated with.
+type rowPlan struct {
+	reuseKey bool
+	key      int64
+}
+
+type updateWorkloadState struct {
+	UpdateWorkload
+
+	keyColumn     int
+	versionColumn int
+	keyGen        *uniqueGenerator
+
+	// the keys of the rows of the current record
+	recordKeys []int64
+}
+
+func newUpdateWorkloadState(
+	workload UpdateWorkload,
+	fields []arrow.Field,
+	generators []columnGenerator,
+) (*updateWorkloadState, error) {
+	state := &updateWorkloadState{
+		UpdateWorkload: workload,
+		keyColumn:      -1,
+		versionColumn:  -1,
+	}
+
+	for i, gen := range generators {
+		if unique, ok := gen.(*uniqueGenerator); ok {
+			if state.keyGen != nil {
+				return nil, errs.NewStackError(
+					fmt.Errorf("%w| the update workload requires exactly one unique column", ErrInvalidDatasetParams),
+				)
+			}
+			state.keyColumn = i
+			state.keyGen = unique
+		}
+		if fields[i].Name == workload.VersionColumn {
+			if _, ok := gen.(*sequenceGenerator); !ok {
+				return nil, errs.NewStackError(
+					fmt.Errorf(
+						"%w| the version column %q must use the %s generator without nulls",
+						ErrInvalidDatasetParams, workload.VersionColumn, GeneratorSequence,
+					),
+				)
+			}
+			state.versionColumn = i
+		}
+	}
+	if state.keyGen == nil {
+		return nil, errs.NewStackError(
+			fmt.Errorf("%w| the update workload requires a non-null %s column", ErrInvalidDatasetParams, GeneratorUnique),
+		)
+	}
+	if state.versionColumn < 0 {
+		return nil, errs.NewStackError(
+			fmt.Errorf("%w| version column %q not found", ErrInvalidDatasetParams, workload.VersionColumn),
+		)
+	}
+
+	return state, nil
+}
+
+func (obj *updateWorkloadState) startRecord() {
+	obj.recordKeys = obj.recordKeys[:0]
+}
+
+func (obj *updateWorkloadState) plan(randGen *rand.Rand) rowPlan {
+	// the first row of a record has no key to reuse, so it gets a new key
+	if randGen.Float64() < obj.InRecordUpdateRatio && len(obj.recordKeys) > 0 {
+		return rowPlan{reuseKey: true, key: obj.recordKeys[randGen.IntN(len(obj.recordKeys))]}
+	}
+	return rowPlan{}
+}
+
+// observe records the key of a generated row.
+func (obj *updateWorkloadState) observe(plan rowPlan) {
+	key := plan.key
+	if !plan.reuseKey {
+		key = obj.keyGen.last
+	}
+	obj.recordKeys = append(obj.recordKeys, key)
+}
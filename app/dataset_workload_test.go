@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestUpdateWorkloadReusesKeysWithinRecord(t *testing.T) {
+	params := DefaultDatasetParams()
+	params.RowsPerRecord = 100
+	params.MaxIterations = 5
+	params.Workload = UpdateWorkload{InRecordUpdateRatio: 0.3, VersionColumn: "sampleId"}
+	specs := []ColumnGeneratorSpec{{Name: "column1", Generator: GeneratorUnique}}
+
+	dataset, err := NewGenericDataset(Table1SourceColumns(), specs, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	mem := memory.NewGoAllocator()
+	seen := make(map[int32]int)
+	updates := 0
+	for i := 0; !dataset.Done(); i++ {
+		record, err := dataset.Next(ctx, mem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := record.Column(0).(*array.Int32)
+		versions := record.Column(4).(*array.Int32)
+		newest := make(map[int32]int32)
+		for row := 0; row < keys.Len(); row++ {
+			key := keys.Value(row)
+			if recordIdx, ok := seen[key]; ok && recordIdx != i {
+				t.Fatalf("key %d of record %d was already sent in record %d", key, i, recordIdx)
+			}
+			if version, ok := newest[key]; ok {
+				updates++
+				if versions.Value(row) <= version {
+					t.Fatalf("update of key %d has version %d, not newer than %d", key, versions.Value(row), version)
+				}
+			}
+			seen[key] = i
+			newest[key] = versions.Value(row)
+		}
+		record.Release()
+	}
+	if updates == 0 {
+		t.Fatal("the workload updated no keys")
+	}
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+/*
+A local Arrow IPC file holding the records which could not be
+inserted into a table. Every record is appended as a stream of its
+own and the file is closed again, so a crash loses at most the record
+being written and a restarted tester keeps appending to the same
+file. ReadDeadLetterFile reads the streams back one after the other
+and reports a stream cut short by a crash instead of failing on it.
+*/
+type DeadLetterFile struct {
+	path      string
+	tableName string
+
+	mu   sync.Mutex
+	rows int64
+}
+
+func NewDeadLetterFile(path string, tableName string) *DeadLetterFile {
+	return &DeadLetterFile{path: path, tableName: tableName}
+}
+
+func (obj *DeadLetterFile) Path() string {
+	return obj.path
+}
+
+// Rows returns the number of rows this file appended since it was created.
+func (obj *DeadLetterFile) Rows() int64 {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	return obj.rows
+}
+
+func (obj *DeadLetterFile) Write(record arrow.Record) error {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+
+	f, err := os.OpenFile(obj.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+
+	writer := ipc.NewWriter(f, ipc.WithSchema(record.Schema()))
+	err = writer.Write(record)
+	if err != nil {
+		writer.Close()
+		f.Close()
+		return errs.NewStackError(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		f.Close()
+		return errs.NewStackError(err)
+	}
+	err = f.Close()
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+
+	obj.rows += record.NumRows()
+	deadLetterRows.WithLabelValues(obj.tableName).Add(float64(record.NumRows()))
+	return nil
+}
+
+/*
+ReadDeadLetterFile returns every record of a dead-letter file. A
+missing file holds no records. When the file ends in the middle of a
+stream, as it does when the tester crashed while appending, the
+records before it are returned and truncated is true; the rows of the
+cut record are lost.
+*/
+func ReadDeadLetterFile(path string, mem memory.Allocator) (records []arrow.Record, truncated bool, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, errs.NewStackError(err)
+	}
+	defer f.Close()
+
+	records = make([]arrow.Record, 0)
+	release := func() {
+		for _, rec := range records {
+			rec.Release()
+		}
+	}
+
+	for {
+		reader, err := ipc.NewReader(f, ipc.WithAllocator(mem))
+		if errors.Is(err, io.EOF) {
+			return records, false, nil
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, true, nil
+		} else if err != nil {
+			release()
+			return nil, false, errs.NewStackError(fmt.Errorf("dead-letter file %s: %w", path, err))
+		}
+
+		for reader.Next() {
+			rec := reader.Record()
+			rec.Retain()
+			records = append(records, rec)
+		}
+		err = reader.Err()
+		reader.Release()
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, true, nil
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			release()
+			return nil, false, errs.NewStackError(fmt.Errorf("dead-letter file %s: %w", path, err))
+		}
+	}
+}
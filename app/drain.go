@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alekLukanen/errs"
+)
+
+/*
+Runs the function until it returns or ctx is cancelled, where run is
+the warehouse's Run. The warehouse claims and processes its tasks
+under the one context it is given, so cancelling ctx both stops it
+from claiming new tasks and cancels the task in progress; RunWithDrain
+then waits up to drainTimeout for run to return. A cancelled run is
+not an error, but running out of drain time is. The partition locks
+of a task which did not finish are not released by the worker; they
+expire in key storage after tasker.taskTimeout.
+*/
+func RunWithDrain(
+	ctx context.Context,
+	logger *slog.Logger,
+	drainTimeout time.Duration,
+	run func(ctx context.Context) error,
+) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- run(ctx)
+	}()
+
+	select {
+	case err := <-errCh:
+		return drainResult(err)
+	case <-ctx.Done():
+	}
+
+	logger.Info(
+		"shutdown requested; waiting for the run loop to stop",
+		slog.Duration("drainTimeout", drainTimeout),
+	)
+	start := time.Now()
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-errCh:
+		logger.Info("the run loop stopped", slog.Duration("drainTime", time.Since(start)))
+		return drainResult(err)
+	case <-timer.C:
+		return errs.NewStackError(fmt.Errorf("%w| waited %s", ErrDrainTimeout, drainTimeout))
+	}
+}
+
+func drainResult(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
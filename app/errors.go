@@ -0,0 +1,39 @@
+package app
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidConfig             = errors.New("invalid config")
+	ErrUnsupportedConfigType     = errors.New("unsupported config file type")
+	ErrInvalidTableSpec          = errors.New("invalid table spec")
+	ErrUnsupportedColumnType     = errors.New("unsupported column type")
+	ErrTransformerNotFound       = errors.New("transformer not found")
+	ErrDuplicateTransformer      = errors.New("duplicate transformer")
+	ErrInvalidTransformer        = errors.New("invalid transformer")
+	ErrColumnExists              = errors.New("column already exists")
+	ErrTableWiring               = errors.New("table wiring invalid")
+	ErrDrainTimeout              = errors.New("drain timeout exceeded")
+	ErrUnsupportedPartition      = errors.New("unsupported partition")
+	ErrPartitionValueOutOfRange  = errors.New("partition value out of range")
+	ErrDatasetNotFound           = errors.New("dataset not found")
+	ErrInvalidDatasetParams      = errors.New("invalid dataset params")
+	ErrDuplicateTable            = errors.New("duplicate table")
+	ErrInvalidDatasetSpec        = errors.New("invalid dataset spec")
+	ErrDuplicateDataset          = errors.New("duplicate dataset")
+	ErrInvalidCheckpoint         = errors.New("invalid dataset checkpoint")
+	ErrIdSpaceExhausted          = errors.New("id space exhausted")
+	ErrInvalidLoadProfile        = errors.New("invalid load profile")
+	ErrInvalidRetryPolicy        = errors.New("invalid retry policy")
+	ErrInsertRetriesExhausted    = errors.New("insert retries exhausted")
+	ErrInsertNotRetryable        = errors.New("insert not retryable")
+	ErrCompletionTimeout         = errors.New("completion timeout exceeded")
+	ErrInvalidValidationSpec     = errors.New("invalid validation spec")
+	ErrAmbiguousNewestRow        = errors.New("ambiguous newest row")
+	ErrUnsupportedReportFormat   = errors.New("unsupported report format")
+	ErrInvalidManifest           = errors.New("invalid manifest")
+	ErrTableStateNotFound        = errors.New("table state not found")
+	ErrInvalidPartitionPredicate = errors.New("invalid partition predicate")
+	ErrPartitionLayoutMismatch   = errors.New("partition layout mismatch")
+)
@@ -0,0 +1,371 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	arrowops "github.com/alekLukanen/arrow-ops"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/compute"
+	"github.com/apache/arrow/go/v17/arrow/csv"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/apache/arrow/go/v17/parquet/file"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+const (
+	FileFormatParquet = "parquet"
+	FileFormatCSV     = "csv"
+	FileFormatNDJSON  = "ndjson"
+	FileFormatIPC     = "ipc"
+)
+
+/*
+Replays recorded rows from files. Paths may be globs and are read in
+sorted order; the format of each file comes from its extension:
+
+  - .parquet: Parquet
+  - .csv: CSV with a header row, empty values are null
+  - .ndjson, .jsonl, .json: one JSON object per line
+  - .arrows, .ipc: Arrow IPC stream
+
+Columns are matched to the target columns by name and cast when the
+types differ; other columns in the files are ignored. The rows of all
+files are emitted in records of rowsPerRecord rows, except for the
+last record which holds what is left.
+*/
+type FileDataset struct {
+	paths         []string
+	rowsPerRecord int64
+	schema        *arrow.Schema
+
+	pathIdx  int
+	reader   array.RecordReader
+	closer   io.Closer
+	buffered []arrow.Record
+	rows     int64
+}
+
+func init() {
+	RegisterDataset("files", func(params DatasetParams) (StreamingDataset, error) {
+		columns, err := subscriptionColumns(params.Table, params.SourceName)
+		if err != nil {
+			return nil, err
+		}
+		return NewFileDataset(columns, params.Files, params.RowsPerRecord)
+	})
+}
+
+func NewFileDataset(columns []elements.Column, patterns []string, rowsPerRecord int) (*FileDataset, error) {
+	if rowsPerRecord <= 0 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| rowsPerRecord must be greater than zero", ErrInvalidDatasetParams))
+	}
+	if len(patterns) == 0 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| the file dataset requires at least one file", ErrInvalidDatasetParams))
+	}
+
+	paths := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errs.NewStackError(fmt.Errorf("%w| pattern %q: %s", ErrInvalidDatasetParams, pattern, err))
+		}
+		if len(matches) == 0 {
+			return nil, errs.NewStackError(fmt.Errorf("%w| no files match %q", ErrInvalidDatasetParams, pattern))
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if _, err := fileFormat(match); err != nil {
+				return nil, err
+			}
+		}
+		paths = append(paths, matches...)
+	}
+
+	fields := make([]arrow.Field, len(columns))
+	for i, col := range columns {
+		fields[i] = arrow.Field{Name: col.Name, Type: col.Dtype, Nullable: true}
+	}
+
+	return &FileDataset{
+		paths:         paths,
+		rowsPerRecord: int64(rowsPerRecord),
+		schema:        arrow.NewSchema(fields, nil),
+	}, nil
+}
+
+func (obj *FileDataset) Schema() *arrow.Schema {
+	return obj.schema
+}
+
+// Paths returns the files the dataset reads, in order.
+func (obj *FileDataset) Paths() []string {
+	return obj.paths
+}
+
+func (obj *FileDataset) Next(ctx context.Context, mem memory.Allocator) (arrow.Record, error) {
+	for obj.rows < obj.rowsPerRecord {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		rec, err := obj.nextFileRecord(ctx, mem)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if rec.NumRows() == 0 {
+			rec.Release()
+			continue
+		}
+		obj.buffered = append(obj.buffered, rec)
+		obj.rows += rec.NumRows()
+	}
+
+	if obj.rows == 0 {
+		return nil, io.EOF
+	}
+	return obj.takeRows(mem, min(obj.rows, obj.rowsPerRecord))
+}
+
+func (obj *FileDataset) Close() error {
+	for _, rec := range obj.buffered {
+		rec.Release()
+	}
+	obj.buffered = nil
+	obj.rows = 0
+	return obj.closeFile()
+}
+
+// nextFileRecord returns the next batch of the current file coerced to
+// the schema, opening the next file when the current one is done.
+func (obj *FileDataset) nextFileRecord(ctx context.Context, mem memory.Allocator) (arrow.Record, error) {
+	for {
+		if obj.reader == nil {
+			if obj.pathIdx >= len(obj.paths) {
+				return nil, io.EOF
+			}
+			err := obj.openFile(ctx, mem, obj.paths[obj.pathIdx])
+			if err != nil {
+				return nil, errs.Wrap(err, fmt.Errorf("file %s", obj.paths[obj.pathIdx]))
+			}
+			obj.pathIdx++
+		}
+
+		if obj.reader.Next() {
+			rec, err := coerceRecord(ctx, mem, obj.reader.Record(), obj.schema)
+			if err != nil {
+				return nil, errs.Wrap(err, fmt.Errorf("file %s", obj.paths[obj.pathIdx-1]))
+			}
+			return rec, nil
+		}
+
+		err := obj.reader.Err()
+		closeErr := obj.closeFile()
+		if err != nil && err != io.EOF {
+			return nil, errs.Wrap(errs.NewStackError(err), fmt.Errorf("file %s", obj.paths[obj.pathIdx-1]))
+		}
+		if closeErr != nil {
+			return nil, closeErr
+		}
+	}
+}
+
+func (obj *FileDataset) openFile(ctx context.Context, mem memory.Allocator, path string) error {
+	format, err := fileFormat(path)
+	if err != nil {
+		return err
+	}
+
+	if format == FileFormatParquet {
+		parquetFile, err := file.OpenParquetFile(path, false)
+		if err != nil {
+			return errs.NewStackError(err)
+		}
+		fileReader, err := pqarrow.NewFileReader(
+			parquetFile,
+			pqarrow.ArrowReadProperties{BatchSize: obj.rowsPerRecord},
+			mem,
+		)
+		if err != nil {
+			parquetFile.Close()
+			return errs.NewStackError(err)
+		}
+		reader, err := fileReader.GetRecordReader(ctx, nil, nil)
+		if err != nil {
+			parquetFile.Close()
+			return errs.NewStackError(err)
+		}
+		obj.reader, obj.closer = reader, parquetFile
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+
+	switch format {
+	case FileFormatCSV:
+		columnTypes := make(map[string]arrow.DataType, len(obj.schema.Fields()))
+		for _, field := range obj.schema.Fields() {
+			columnTypes[field.Name] = field.Type
+		}
+		obj.reader = csv.NewInferringReader(
+			f,
+			csv.WithHeader(true),
+			csv.WithColumnTypes(columnTypes),
+			csv.WithNullReader(true, ""),
+			csv.WithChunk(int(obj.rowsPerRecord)),
+			csv.WithAllocator(mem),
+		)
+	case FileFormatNDJSON:
+		obj.reader = array.NewJSONReader(
+			f,
+			obj.schema,
+			array.WithChunk(int(obj.rowsPerRecord)),
+			array.WithAllocator(mem),
+		)
+	case FileFormatIPC:
+		reader, err := ipc.NewReader(f, ipc.WithAllocator(mem))
+		if err != nil {
+			f.Close()
+			return errs.NewStackError(err)
+		}
+		obj.reader = reader
+	}
+	obj.closer = f
+	return nil
+}
+
+func (obj *FileDataset) closeFile() error {
+	if obj.reader != nil {
+		obj.reader.Release()
+		obj.reader = nil
+	}
+	if obj.closer != nil {
+		err := obj.closer.Close()
+		obj.closer = nil
+		if err != nil {
+			return errs.NewStackError(err)
+		}
+	}
+	return nil
+}
+
+// takeRows copies the first n buffered rows into a new record and keeps the rest.
+func (obj *FileDataset) takeRows(mem memory.Allocator, n int64) (arrow.Record, error) {
+	parts := make([]arrow.Record, 0, len(obj.buffered))
+	remaining := n
+	for remaining > 0 {
+		rec := obj.buffered[0]
+		if rec.NumRows() <= remaining {
+			parts = append(parts, rec)
+			obj.buffered = obj.buffered[1:]
+			remaining -= rec.NumRows()
+			continue
+		}
+		parts = append(parts, rec.NewSlice(0, remaining))
+		obj.buffered[0] = rec.NewSlice(remaining, rec.NumRows())
+		rec.Release()
+		remaining = 0
+	}
+	obj.rows -= n
+	defer func() {
+		for _, part := range parts {
+			part.Release()
+		}
+	}()
+
+	columns := make([]arrow.Array, obj.schema.NumFields())
+	defer func() {
+		for _, col := range columns {
+			if col != nil {
+				col.Release()
+			}
+		}
+	}()
+	for i := range columns {
+		chunks := make([]arrow.Array, len(parts))
+		for j, part := range parts {
+			chunks[j] = part.Column(i)
+		}
+		col, err := array.Concatenate(chunks, mem)
+		if err != nil {
+			return nil, errs.NewStackError(err)
+		}
+		columns[i] = col
+	}
+
+	return array.NewRecord(obj.schema, columns, n), nil
+}
+
+func fileFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".parquet":
+		return FileFormatParquet, nil
+	case ".csv":
+		return FileFormatCSV, nil
+	case ".ndjson", ".jsonl", ".json":
+		return FileFormatNDJSON, nil
+	case ".arrows", ".ipc":
+		return FileFormatIPC, nil
+	default:
+		return "", errs.NewStackError(fmt.Errorf("%w| file: %s", ErrUnsupportedConfigType, path))
+	}
+}
+
+/*
+Returns the columns of the schema taken from the record by name,
+casting those whose type differs. The result is a new record which
+the caller owns; the input record is not released.
+*/
+func coerceRecord(ctx context.Context, mem memory.Allocator, record arrow.Record, schema *arrow.Schema) (arrow.Record, error) {
+	columns := make([]arrow.Array, schema.NumFields())
+	release := func() {
+		for _, col := range columns {
+			if col != nil {
+				col.Release()
+			}
+		}
+	}
+
+	castCtx := compute.WithAllocator(ctx, mem)
+	for i, field := range schema.Fields() {
+		colIdx := record.Schema().FieldIndices(field.Name)
+		if len(colIdx) == 0 {
+			release()
+			return nil, errs.NewStackError(fmt.Errorf("%w| column name: %s", arrowops.ErrColumnNotFound, field.Name))
+		}
+
+		col := record.Column(colIdx[0])
+		if arrow.TypeEqual(col.DataType(), field.Type) {
+			col.Retain()
+			columns[i] = col
+			continue
+		}
+
+		castCol, err := compute.CastArray(castCtx, col, compute.SafeCastOptions(field.Type))
+		if err != nil {
+			release()
+			return nil, errs.NewStackError(
+				fmt.Errorf("%w| column %s from %s to %s: %s", arrowops.ErrUnsupportedDataType, field.Name, col.DataType(), field.Type, err),
+			)
+		}
+		columns[i] = castCol
+	}
+
+	rec := array.NewRecord(schema, columns, record.NumRows())
+	release()
+	return rec, nil
+}
@@ -0,0 +1,566 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/decimal128"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+const (
+	GeneratorUnique      = "unique"
+	GeneratorSequence    = "sequence"
+	GeneratorCategorical = "categorical"
+	GeneratorUniform     = "uniform"
+	GeneratorNormal      = "normal"
+
+	defaultListLength = 3
+)
+
+/*
+Configures how the values of one column are generated. Which fields
+apply depends on the generator:
+
+  - unique: distinct random integers in [min, max); max defaults to
+    the dataset's maxIdValue. Ids come from a seeded permutation of
+    the range, so no memory is kept per generated id
+  - sequence: start, start+step, start+2*step, ... by row number
+  - categorical: a random entry of values, parsed as the column type
+  - uniform: random floats in [min, max)
+  - normal: random floats with the given mean and stdDev
+
+Prefix is prepended to unique and sequence values of string columns,
+nullRatio is the share of rows left null and listLength is the number
+of elements in each row of a list column.
+*/
+type ColumnGeneratorSpec struct {
+	Name       string   `yaml:"name" json:"name"`
+	Generator  string   `yaml:"generator" json:"generator"`
+	Min        float64  `yaml:"min" json:"min"`
+	Max        float64  `yaml:"max" json:"max"`
+	Start      int64    `yaml:"start" json:"start"`
+	Step       int64    `yaml:"step" json:"step"`
+	Values     []string `yaml:"values" json:"values"`
+	Mean       float64  `yaml:"mean" json:"mean"`
+	StdDev     float64  `yaml:"stdDev" json:"stdDev"`
+	Prefix     string   `yaml:"prefix" json:"prefix"`
+	NullRatio  float64  `yaml:"nullRatio" json:"nullRatio"`
+	ListLength int      `yaml:"listLength" json:"listLength"`
+}
+
+/*
+Generates records for any list of columns, typically the source
+columns of a table's subscription. Columns without a generator spec
+fall back to a default chosen by their type. Every random draw comes
+from a single generator seeded by DatasetParams.Seed, so two datasets
+built from the same columns, specs and params produce the same
+records.
+*/
+type GenericDataset struct {
+	iterationsCompleted int
+	rowsGenerated       int
+
+	rowsPerRecord int
+	maxIterations int
+
+	seed       uint64
+	schema     *arrow.Schema
+	generators []columnGenerator
+	uniqueIds  map[string]*uniqueGenerator
+	workload   *updateWorkloadState
+	randSource *rand.PCG
+	randGen    *rand.Rand
+}
+
+func init() {
+	RegisterDataset("random", func(params DatasetParams) (StreamingDataset, error) {
+		columns, err := subscriptionColumns(params.Table, params.SourceName)
+		if err != nil {
+			return nil, err
+		}
+		return NewGenericDataset(columns, nil, params)
+	})
+}
+
+func NewGenericDataset(columns []elements.Column, specs []ColumnGeneratorSpec, params DatasetParams) (*GenericDataset, error) {
+	err := params.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	specsByName := make(map[string]ColumnGeneratorSpec, len(specs))
+	for _, spec := range specs {
+		specsByName[spec.Name] = spec
+	}
+
+	fields := make([]arrow.Field, 0, len(columns))
+	generators := make([]columnGenerator, 0, len(columns))
+	uniqueIds := make(map[string]*uniqueGenerator)
+	for _, col := range columns {
+		spec, ok := specsByName[col.Name]
+		if !ok {
+			spec = defaultColumnGeneratorSpec(col)
+		}
+		delete(specsByName, col.Name)
+
+		gen, err := newColumnGenerator(col, spec, params)
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("column %q", col.Name))
+		}
+		if unique := findUniqueGenerator(gen); unique != nil {
+			uniqueIds[col.Name] = unique
+		}
+		fields = append(fields, arrow.Field{Name: col.Name, Type: col.Dtype, Nullable: spec.NullRatio > 0})
+		generators = append(generators, gen)
+	}
+	for name := range specsByName {
+		return nil, errs.NewStackError(fmt.Errorf("%w| generator for unknown column %q", ErrInvalidDatasetParams, name))
+	}
+
+	var workload *updateWorkloadState
+	if params.Workload.Enabled() {
+		workload, err = newUpdateWorkloadState(params.Workload, fields, generators)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	randSource := rand.NewPCG(params.Seed, 1024)
+	return &GenericDataset{
+		rowsPerRecord: params.RowsPerRecord,
+		maxIterations: params.MaxIterations,
+		seed:          params.Seed,
+		schema:        arrow.NewSchema(fields, nil),
+		generators:    generators,
+		uniqueIds:     uniqueIds,
+		workload:      workload,
+		randSource:    randSource,
+		randGen:       rand.New(randSource),
+	}, nil
+}
+
+func (obj *GenericDataset) Schema() *arrow.Schema {
+	return obj.schema
+}
+
+func (obj *GenericDataset) Done() bool {
+	return obj.iterationsCompleted >= obj.maxIterations
+}
+
+// BuildRecord panics where Next would return an error.
+func (obj *GenericDataset) BuildRecord(mem *memory.GoAllocator) arrow.Record {
+	rec, err := obj.buildRecord(mem)
+	if err != nil {
+		panic(errs.ErrorWithStack(err))
+	}
+	return rec
+}
+
+func (obj *GenericDataset) Next(ctx context.Context, mem memory.Allocator) (arrow.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if obj.Done() {
+		return nil, io.EOF
+	}
+	return obj.buildRecord(mem)
+}
+
+func (obj *GenericDataset) Close() error {
+	return nil
+}
+
+/*
+The generators and column types are checked when the dataset is
+built; what can still fail is a value which does not fit its
+column, such as a normal draw overflowing a decimal's precision.
+*/
+func (obj *GenericDataset) buildRecord(mem memory.Allocator) (arrow.Record, error) {
+	recBuilder := array.NewRecordBuilder(mem, obj.schema)
+	defer recBuilder.Release()
+
+	if obj.workload != nil {
+		obj.workload.startRecord()
+	}
+
+	for row := int64(obj.rowsGenerated); row < int64(obj.rowsGenerated+obj.rowsPerRecord); row++ {
+		if obj.workload == nil {
+			for i, gen := range obj.generators {
+				err := gen.append(recBuilder.Field(i), obj.randGen, row)
+				if err != nil {
+					return nil, errs.Wrap(err, fmt.Errorf("column %q", obj.schema.Field(i).Name))
+				}
+			}
+			continue
+		}
+
+		plan := obj.workload.plan(obj.randGen)
+		for i, gen := range obj.generators {
+			var err error
+			if i == obj.workload.keyColumn && plan.reuseKey {
+				err = obj.workload.keyGen.appendId(recBuilder.Field(i), plan.key)
+			} else {
+				err = gen.append(recBuilder.Field(i), obj.randGen, row)
+			}
+			if err != nil {
+				return nil, errs.Wrap(err, fmt.Errorf("column %q", obj.schema.Field(i).Name))
+			}
+		}
+		obj.workload.observe(plan)
+	}
+
+	obj.rowsGenerated += obj.rowsPerRecord
+	obj.iterationsCompleted++
+
+	return recBuilder.NewRecord(), nil
+}
+
+// subscriptionColumns returns the source columns of the named subscription,
+// or of the table's first subscription when sourceName is empty.
+func subscriptionColumns(table *elements.Table, sourceName string) ([]elements.Column, error) {
+	if table == nil {
+		return nil, errs.NewStackError(fmt.Errorf("%w| the dataset requires a table", ErrInvalidDatasetParams))
+	}
+	for _, group := range table.SubscriptionGroups() {
+		for _, sub := range group.Subscriptions() {
+			if sourceName == "" || sub.SourceName() == sourceName {
+				return sub.Columns(), nil
+			}
+		}
+	}
+	return nil, errs.NewStackError(
+		fmt.Errorf("%w| table %q has no subscription for source %q", ErrInvalidDatasetParams, table.TableName(), sourceName),
+	)
+}
+
+func defaultColumnGeneratorSpec(col elements.Column) ColumnGeneratorSpec {
+	dtype := col.Dtype
+	if listType, ok := dtype.(*arrow.ListType); ok {
+		dtype = listType.Elem()
+	}
+
+	switch {
+	case arrow.IsFloating(dtype.ID()):
+		return ColumnGeneratorSpec{Name: col.Name, Generator: GeneratorUniform, Min: 0, Max: 1}
+	case dtype.ID() == arrow.BOOL:
+		return ColumnGeneratorSpec{Name: col.Name, Generator: GeneratorCategorical, Values: []string{"true", "false"}}
+	case dtype.ID() == arrow.STRING || dtype.ID() == arrow.BINARY:
+		return ColumnGeneratorSpec{Name: col.Name, Generator: GeneratorSequence, Step: 1, Prefix: col.Name + "-"}
+	default:
+		return ColumnGeneratorSpec{Name: col.Name, Generator: GeneratorSequence, Step: 1}
+	}
+}
+
+type columnGenerator interface {
+	append(builder array.Builder, randGen *rand.Rand, row int64) error
+}
+
+func newColumnGenerator(col elements.Column, spec ColumnGeneratorSpec, params DatasetParams) (columnGenerator, error) {
+	if spec.NullRatio < 0 || spec.NullRatio > 1 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| nullRatio must be between 0 and 1", ErrInvalidDatasetParams))
+	}
+
+	valueType := col.Dtype
+	listType, isList := col.Dtype.(*arrow.ListType)
+	if isList {
+		valueType = listType.Elem()
+	} else if spec.ListLength != 0 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| listLength requires a list column", ErrInvalidDatasetParams))
+	}
+	if !generatedTypeSupported(valueType) {
+		return nil, errs.NewStackError(fmt.Errorf("%w| %s", ErrUnsupportedColumnType, col.Dtype))
+	}
+
+	rowsPerRow := 1
+	if isList {
+		if spec.ListLength < 0 {
+			return nil, errs.NewStackError(fmt.Errorf("%w| listLength must not be negative", ErrInvalidDatasetParams))
+		}
+		if spec.ListLength == 0 {
+			spec.ListLength = defaultListLength
+		}
+		rowsPerRow = spec.ListLength
+	}
+
+	var gen columnGenerator
+	switch spec.Generator {
+	case GeneratorUnique:
+		if spec.Max == 0 {
+			spec.Max = float64(params.MaxIdValue)
+		}
+		minVal, maxVal := int64(spec.Min), int64(spec.Max)
+		if maxVal <= minVal {
+			return nil, errs.NewStackError(fmt.Errorf("%w| unique max must be greater than min", ErrInvalidDatasetParams))
+		}
+		needed := int64(params.RowsPerRecord) * int64(params.MaxIterations) * int64(rowsPerRow)
+		if needed > maxVal-minVal {
+			return nil, errs.NewStackError(
+				fmt.Errorf("%w| %d unique values requested from a range of %d", ErrInvalidDatasetParams, needed, maxVal-minVal),
+			)
+		}
+		// every column gets its own order of ids
+		columnHash := fnv.New64a()
+		columnHash.Write([]byte(col.Name))
+		ids, err := NewIdPermutation(uint64(maxVal-minVal), params.Seed^columnHash.Sum64())
+		if err != nil {
+			return nil, err
+		}
+		gen = &uniqueGenerator{
+			minVal: minVal,
+			prefix: spec.Prefix,
+			ids:    ids,
+		}
+	case GeneratorSequence:
+		if spec.Step == 0 {
+			spec.Step = 1
+		}
+		gen = &sequenceGenerator{start: spec.Start, step: spec.Step, prefix: spec.Prefix}
+	case GeneratorCategorical:
+		if len(spec.Values) == 0 {
+			return nil, errs.NewStackError(fmt.Errorf("%w| categorical values must not be empty", ErrInvalidDatasetParams))
+		}
+		// parse every value once up front so bad values fail here
+		builder := array.NewBuilder(memory.NewGoAllocator(), valueType)
+		defer builder.Release()
+		for _, value := range spec.Values {
+			err := builder.AppendValueFromString(value)
+			if err != nil {
+				return nil, errs.NewStackError(
+					fmt.Errorf("%w| categorical value %q is not a valid %s", ErrInvalidDatasetParams, value, valueType),
+				)
+			}
+		}
+		gen = &categoricalGenerator{values: spec.Values}
+	case GeneratorUniform:
+		if spec.Max <= spec.Min {
+			return nil, errs.NewStackError(fmt.Errorf("%w| uniform max must be greater than min", ErrInvalidDatasetParams))
+		}
+		gen = &uniformGenerator{minVal: spec.Min, maxVal: spec.Max}
+	case GeneratorNormal:
+		if spec.StdDev < 0 {
+			return nil, errs.NewStackError(fmt.Errorf("%w| normal stdDev must not be negative", ErrInvalidDatasetParams))
+		}
+		gen = &normalGenerator{mean: spec.Mean, stdDev: spec.StdDev}
+	default:
+		return nil, errs.NewStackError(
+			fmt.Errorf("%w| generator %q is not one of %s", ErrInvalidDatasetParams, spec.Generator, strings.Join([]string{
+				GeneratorUnique, GeneratorSequence, GeneratorCategorical, GeneratorUniform, GeneratorNormal,
+			}, ", ")),
+		)
+	}
+
+	if isList {
+		gen = &listGenerator{elem: gen, length: spec.ListLength}
+	}
+	if spec.NullRatio > 0 {
+		gen = &nullableGenerator{value: gen, nullRatio: spec.NullRatio}
+	}
+	return gen, nil
+}
+
+func generatedTypeSupported(dtype arrow.DataType) bool {
+	switch dtype.ID() {
+	case arrow.BOOL,
+		arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64,
+		arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64,
+		arrow.FLOAT32, arrow.FLOAT64,
+		arrow.STRING, arrow.BINARY,
+		arrow.DATE32, arrow.DATE64, arrow.TIMESTAMP,
+		arrow.DECIMAL128:
+		return true
+	default:
+		return false
+	}
+}
+
+// findUniqueGenerator returns the unique generator wrapped by gen, if any.
+func findUniqueGenerator(gen columnGenerator) *uniqueGenerator {
+	switch g := gen.(type) {
+	case *uniqueGenerator:
+		return g
+	case *listGenerator:
+		return findUniqueGenerator(g.elem)
+	case *nullableGenerator:
+		return findUniqueGenerator(g.value)
+	default:
+		return nil
+	}
+}
+
+type uniqueGenerator struct {
+	minVal int64
+	prefix string
+	ids    *IdPermutation
+	last   int64
+}
+
+func (obj *uniqueGenerator) append(builder array.Builder, randGen *rand.Rand, row int64) error {
+	id, err := obj.ids.Next()
+	if err != nil {
+		return err
+	}
+	obj.last = obj.minVal + int64(id)
+	return appendInt(builder, obj.last, obj.prefix)
+}
+
+// idAt returns the index-th id the generator produces.
+func (obj *uniqueGenerator) idAt(index int64) int64 {
+	return obj.minVal + int64(obj.ids.At(uint64(index)))
+}
+
+// appendId appends an id which was generated before.
+func (obj *uniqueGenerator) appendId(builder array.Builder, id int64) error {
+	return appendInt(builder, id, obj.prefix)
+}
+
+type sequenceGenerator struct {
+	start, step int64
+	prefix      string
+}
+
+func (obj *sequenceGenerator) append(builder array.Builder, randGen *rand.Rand, row int64) error {
+	return appendInt(builder, obj.start+obj.step*row, obj.prefix)
+}
+
+type categoricalGenerator struct {
+	values []string
+}
+
+func (obj *categoricalGenerator) append(builder array.Builder, randGen *rand.Rand, row int64) error {
+	return builder.AppendValueFromString(obj.values[randGen.IntN(len(obj.values))])
+}
+
+type uniformGenerator struct {
+	minVal, maxVal float64
+}
+
+func (obj *uniformGenerator) append(builder array.Builder, randGen *rand.Rand, row int64) error {
+	return appendFloat(builder, obj.minVal+randGen.Float64()*(obj.maxVal-obj.minVal))
+}
+
+type normalGenerator struct {
+	mean, stdDev float64
+}
+
+func (obj *normalGenerator) append(builder array.Builder, randGen *rand.Rand, row int64) error {
+	return appendFloat(builder, obj.mean+randGen.NormFloat64()*obj.stdDev)
+}
+
+type listGenerator struct {
+	elem   columnGenerator
+	length int
+}
+
+func (obj *listGenerator) append(builder array.Builder, randGen *rand.Rand, row int64) error {
+	listBuilder, ok := builder.(*array.ListBuilder)
+	if !ok {
+		return errs.NewStackError(fmt.Errorf("%w| expected a list builder, got %T", ErrUnsupportedColumnType, builder))
+	}
+	listBuilder.Append(true)
+	for i := 0; i < obj.length; i++ {
+		err := obj.elem.append(listBuilder.ValueBuilder(), randGen, row*int64(obj.length)+int64(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type nullableGenerator struct {
+	value     columnGenerator
+	nullRatio float64
+}
+
+func (obj *nullableGenerator) append(builder array.Builder, randGen *rand.Rand, row int64) error {
+	if randGen.Float64() < obj.nullRatio {
+		builder.AppendNull()
+		return nil
+	}
+	return obj.value.append(builder, randGen, row)
+}
+
+// appendInt appends value converted to the builder's type. Booleans are
+// true for even values and the prefix only applies to strings and binary.
+func appendInt(builder array.Builder, value int64, prefix string) error {
+	switch b := builder.(type) {
+	case *array.BooleanBuilder:
+		b.Append(value%2 == 0)
+	case *array.Int8Builder:
+		b.Append(int8(value))
+	case *array.Int16Builder:
+		b.Append(int16(value))
+	case *array.Int32Builder:
+		b.Append(int32(value))
+	case *array.Int64Builder:
+		b.Append(value)
+	case *array.Uint8Builder:
+		b.Append(uint8(value))
+	case *array.Uint16Builder:
+		b.Append(uint16(value))
+	case *array.Uint32Builder:
+		b.Append(uint32(value))
+	case *array.Uint64Builder:
+		b.Append(uint64(value))
+	case *array.Float32Builder:
+		b.Append(float32(value))
+	case *array.Float64Builder:
+		b.Append(float64(value))
+	case *array.StringBuilder:
+		b.Append(prefix + strconv.FormatInt(value, 10))
+	case *array.BinaryBuilder:
+		b.Append([]byte(prefix + strconv.FormatInt(value, 10)))
+	case *array.Date32Builder:
+		b.Append(arrow.Date32(value))
+	case *array.Date64Builder:
+		b.Append(arrow.Date64(value))
+	case *array.TimestampBuilder:
+		b.Append(arrow.Timestamp(value))
+	case *array.Decimal128Builder:
+		return appendDecimal(b, float64(value))
+	default:
+		return errs.NewStackError(fmt.Errorf("%w| %s", ErrUnsupportedColumnType, builder.Type()))
+	}
+	return nil
+}
+
+// appendFloat appends value converted to the builder's type. Integer
+// types truncate and booleans are true below 0.5.
+func appendFloat(builder array.Builder, value float64) error {
+	switch b := builder.(type) {
+	case *array.BooleanBuilder:
+		b.Append(value < 0.5)
+	case *array.Float32Builder:
+		b.Append(float32(value))
+	case *array.Float64Builder:
+		b.Append(value)
+	case *array.StringBuilder:
+		b.Append(strconv.FormatFloat(value, 'f', -1, 64))
+	case *array.BinaryBuilder:
+		b.Append([]byte(strconv.FormatFloat(value, 'f', -1, 64)))
+	case *array.Decimal128Builder:
+		return appendDecimal(b, value)
+	default:
+		return appendInt(builder, int64(value), "")
+	}
+	return nil
+}
+
+func appendDecimal(builder *array.Decimal128Builder, value float64) error {
+	dtype := builder.Type().(*arrow.Decimal128Type)
+	num, err := decimal128.FromFloat64(value, dtype.Precision, dtype.Scale)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+	builder.Append(num)
+	return nil
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/operations"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+)
+
+// Heartbeat records the last time the worker made progress: a
+// subscription transformed a record or the task queue was seen empty.
+type Heartbeat struct {
+	last atomic.Int64
+}
+
+func (obj *Heartbeat) Beat() {
+	obj.last.Store(time.Now().UnixNano())
+}
+
+// Age is the time since the last beat; it is negative if there was none.
+func (obj *Heartbeat) Age() time.Duration {
+	last := obj.last.Load()
+	if last == 0 {
+		return -1
+	}
+	return time.Since(time.Unix(0, last))
+}
+
+type HealthCheckResult struct {
+	Name   string         `json:"name"`
+	Ok     bool           `json:"ok"`
+	Error  string         `json:"error,omitempty"`
+	Detail map[string]any `json:"detail,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string              `json:"status"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+/*
+HealthChecker serves the worker's Kubernetes probes:
+
+  - /healthz: the process is alive and serving HTTP
+  - /readyz: object storage, key storage and the table registry are usable
+  - /livez: the worker made progress within the heartbeat timeout
+*/
+type HealthChecker struct {
+	logger *slog.Logger
+	cfg    *Config
+
+	heartbeat     *Heartbeat
+	tableRegistry atomic.Pointer[operations.TableRegistry]
+
+	s3Client    *s3.Client
+	redisClient *redis.Client
+}
+
+func NewHealthChecker(logger *slog.Logger, cfg *Config) *HealthChecker {
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     cfg.KeyStorage.Address,
+		Password: cfg.KeyStorage.Password,
+	})
+
+	return &HealthChecker{
+		logger:      logger,
+		cfg:         cfg,
+		heartbeat:   &Heartbeat{},
+		s3Client:    newS3Client(cfg),
+		redisClient: redisClient,
+	}
+}
+
+func (obj *HealthChecker) Heartbeat() *Heartbeat {
+	return obj.heartbeat
+}
+
+// SetTableRegistry marks the table registry as loaded.
+func (obj *HealthChecker) SetTableRegistry(tableRegistry *operations.TableRegistry) {
+	obj.tableRegistry.Store(tableRegistry)
+}
+
+func (obj *HealthChecker) Close() error {
+	return obj.redisClient.Close()
+}
+
+func (obj *HealthChecker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		obj.writeResponse(w, []HealthCheckResult{{Name: "process", Ok: true}})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), obj.cfg.Health.CheckTimeout)
+		defer cancel()
+		obj.writeResponse(w, []HealthCheckResult{
+			obj.checkObjectStorage(ctx),
+			obj.checkKeyStorage(ctx),
+			obj.checkTableRegistry(),
+		})
+	})
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		obj.writeResponse(w, []HealthCheckResult{obj.checkHeartbeat()})
+	})
+	return mux
+}
+
+func (obj *HealthChecker) writeResponse(w http.ResponseWriter, checks []HealthCheckResult) {
+	resp := HealthResponse{Status: "ok", Checks: checks}
+	statusCode := http.StatusOK
+	for _, check := range checks {
+		if !check.Ok {
+			resp.Status = "failing"
+			statusCode = http.StatusServiceUnavailable
+			obj.logger.Warn(
+				"health check failed",
+				slog.String("check", check.Name),
+				slog.String("error", check.Error),
+			)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		obj.logger.Error("failed to write health response", slog.String("error", err.Error()))
+	}
+}
+
+func (obj *HealthChecker) checkObjectStorage(ctx context.Context) HealthCheckResult {
+	result := HealthCheckResult{
+		Name: "objectStorage",
+		Detail: map[string]any{
+			"endpoint": obj.cfg.ObjectStorage.Endpoint,
+			"bucket":   obj.cfg.Manifest.BucketName,
+		},
+	}
+	_, err := obj.s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(obj.cfg.Manifest.BucketName),
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Ok = true
+	return result
+}
+
+func (obj *HealthChecker) checkKeyStorage(ctx context.Context) HealthCheckResult {
+	result := HealthCheckResult{
+		Name:   "keyStorage",
+		Detail: map[string]any{"address": obj.cfg.KeyStorage.Address},
+	}
+	err := obj.redisClient.Ping(ctx).Err()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Ok = true
+	return result
+}
+
+func (obj *HealthChecker) checkTableRegistry() HealthCheckResult {
+	result := HealthCheckResult{Name: "tableRegistry"}
+	tableRegistry := obj.tableRegistry.Load()
+	if tableRegistry == nil {
+		result.Error = "table registry not loaded"
+		return result
+	}
+
+	tableNames := make([]string, 0)
+	for _, tbl := range tableRegistry.Tables() {
+		tableNames = append(tableNames, tbl.TableName())
+	}
+	result.Detail = map[string]any{"tables": tableNames}
+	result.Ok = true
+	return result
+}
+
+func (obj *HealthChecker) checkHeartbeat() HealthCheckResult {
+	result := HealthCheckResult{Name: "runLoop"}
+	age := obj.heartbeat.Age()
+	if age < 0 {
+		result.Error = "no progress since the worker started"
+		return result
+	}
+
+	result.Detail = map[string]any{
+		"heartbeatAge":     age.String(),
+		"heartbeatTimeout": obj.cfg.Health.HeartbeatTimeout.String(),
+	}
+	if age > obj.cfg.Health.HeartbeatTimeout {
+		result.Error = fmt.Sprintf("last heartbeat was %s ago", age.Round(time.Millisecond))
+		return result
+	}
+	result.Ok = true
+	return result
+}
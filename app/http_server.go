@@ -0,0 +1,26 @@
+package app
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// StartHTTPServer serves handler on address in the background. The
+// caller is responsible for closing the returned server.
+func StartHTTPServer(logger *slog.Logger, name, address string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		logger.Info("starting http server", slog.String("server", name), slog.String("address", address))
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http server failed", slog.String("server", name), slog.String("error", err.Error()))
+		}
+	}()
+	return server
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/alekLukanen/errs"
+)
+
+const idPermutationRounds = 4
+
+/*
+Produces every integer in [0, size) exactly once in a random order
+using constant memory. The order is a bijection built from a small
+Feistel network over the next even power of two at or above size;
+values which land outside [0, size) are fed through the network
+again (cycle walking) until they land inside. Since that domain is
+at most four times size, a value takes fewer than four passes on
+average.
+
+The permutation is fixed by the seed, so the position is the only
+state that changes: At(i) is the i-th id and Next returns At of the
+current position and advances it.
+*/
+type IdPermutation struct {
+	size     uint64
+	halfBits uint
+	halfMask uint64
+	keys     [idPermutationRounds]uint64
+
+	position uint64
+}
+
+func NewIdPermutation(size uint64, seed uint64) (*IdPermutation, error) {
+	if size == 0 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| the id space must not be empty", ErrInvalidDatasetParams))
+	}
+
+	domainBits := uint(bits.Len64(size - 1))
+	halfBits := max((domainBits+1)/2, 1)
+	if halfBits > 32 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| the id space %d is too large", ErrInvalidDatasetParams, size))
+	}
+
+	perm := &IdPermutation{
+		size:     size,
+		halfBits: halfBits,
+		halfMask: (uint64(1) << halfBits) - 1,
+	}
+	state := seed
+	for i := range perm.keys {
+		state += 0x9e3779b97f4a7c15
+		perm.keys[i] = mix64(state)
+	}
+	return perm, nil
+}
+
+func (obj *IdPermutation) Size() uint64 {
+	return obj.size
+}
+
+func (obj *IdPermutation) Position() uint64 {
+	return obj.position
+}
+
+func (obj *IdPermutation) Remaining() uint64 {
+	return obj.size - obj.position
+}
+
+// SetPosition moves the permutation so that Next returns At(position).
+func (obj *IdPermutation) SetPosition(position uint64) error {
+	if position > obj.size {
+		return errs.NewStackError(
+			fmt.Errorf("%w| position %d is past the end of an id space of %d", ErrInvalidCheckpoint, position, obj.size),
+		)
+	}
+	obj.position = position
+	return nil
+}
+
+// Next returns the next id or ErrIdSpaceExhausted once all size ids were returned.
+func (obj *IdPermutation) Next() (uint64, error) {
+	if obj.position >= obj.size {
+		return 0, errs.NewStackError(fmt.Errorf("%w| all %d ids were used", ErrIdSpaceExhausted, obj.size))
+	}
+	id := obj.At(obj.position)
+	obj.position++
+	return id, nil
+}
+
+// At returns the id at index of the permutation; index must be less than Size.
+func (obj *IdPermutation) At(index uint64) uint64 {
+	value := obj.feistel(index)
+	for value >= obj.size {
+		value = obj.feistel(value)
+	}
+	return value
+}
+
+func (obj *IdPermutation) feistel(value uint64) uint64 {
+	left := (value >> obj.halfBits) & obj.halfMask
+	right := value & obj.halfMask
+	for _, key := range obj.keys {
+		left, right = right, left^(mix64(right^key)&obj.halfMask)
+	}
+	return (left << obj.halfBits) | right
+}
+
+// mix64 is the splitmix64 finalizer.
+func mix64(value uint64) uint64 {
+	value ^= value >> 30
+	value *= 0xbf58476d1ce4e5b9
+	value ^= value >> 27
+	value *= 0x94d049bb133111eb
+	value ^= value >> 31
+	return value
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIdPermutation(t *testing.T) {
+	tests := []struct {
+		name string
+		size uint64
+		seed uint64
+	}{
+		{"single id", 1, 0},
+		{"two ids", 2, 0},
+		{"three ids", 3, 1},
+		{"seven ids", 7, 42},
+		{"thousand ids", 1000, 42},
+		{"thousand ids other seed", 1000, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perm, err := NewIdPermutation(tt.size, tt.seed)
+			if err != nil {
+				t.Fatalf("NewIdPermutation(%d, %d): %v", tt.size, tt.seed, err)
+			}
+
+			seen := make(map[uint64]bool, tt.size)
+			for i := uint64(0); i < tt.size; i++ {
+				id, err := perm.Next()
+				if err != nil {
+					t.Fatalf("Next() at position %d: %v", i, err)
+				}
+				if id >= tt.size {
+					t.Fatalf("Next() at position %d returned %d, outside [0, %d)", i, id, tt.size)
+				}
+				if seen[id] {
+					t.Fatalf("Next() at position %d returned %d twice", i, id)
+				}
+				seen[id] = true
+
+				if at := perm.At(i); at != id {
+					t.Fatalf("At(%d) = %d, Next() returned %d", i, at, id)
+				}
+			}
+			if perm.Remaining() != 0 {
+				t.Fatalf("Remaining() = %d after every id, want 0", perm.Remaining())
+			}
+
+			_, err = perm.Next()
+			if !errors.Is(err, ErrIdSpaceExhausted) {
+				t.Fatalf("Next() after every id: got %v, want ErrIdSpaceExhausted", err)
+			}
+		})
+	}
+}
+
+func TestIdPermutationSetPosition(t *testing.T) {
+	perm, err := NewIdPermutation(7, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = perm.SetPosition(5)
+	if err != nil {
+		t.Fatalf("SetPosition(5): %v", err)
+	}
+	id, err := perm.Next()
+	if err != nil {
+		t.Fatalf("Next(): %v", err)
+	}
+	if id != perm.At(5) {
+		t.Fatalf("Next() after SetPosition(5) = %d, want At(5) = %d", id, perm.At(5))
+	}
+
+	err = perm.SetPosition(8)
+	if !errors.Is(err, ErrInvalidCheckpoint) {
+		t.Fatalf("SetPosition(8) on 7 ids: got %v, want ErrInvalidCheckpoint", err)
+	}
+}
+
+func TestIdPermutationRejectsEmptySpace(t *testing.T) {
+	_, err := NewIdPermutation(0, 0)
+	if !errors.Is(err, ErrInvalidDatasetParams) {
+		t.Fatalf("NewIdPermutation(0, 0): got %v, want ErrInvalidDatasetParams", err)
+	}
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	arrowops "github.com/alekLukanen/arrow-ops"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+/*
+Keeps a copy of every record inserted into a table as a parquet file
+in a directory, which is how the tester knows what the warehouse must
+eventually hold. The files are named by the time they were written so
+that a restarted tester appends to the journal of the run it resumes.
+*/
+type InsertJournal struct {
+	dir string
+
+	mu       sync.Mutex
+	records  int64
+	rows     int64
+	lostRows int64
+}
+
+func NewInsertJournal(dir string) (*InsertJournal, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+	return &InsertJournal{dir: dir}, nil
+}
+
+func (obj *InsertJournal) Dir() string {
+	return obj.dir
+}
+
+// Pattern returns the glob matching every file of the journal.
+func (obj *InsertJournal) Pattern() string {
+	return filepath.Join(obj.dir, "*.parquet")
+}
+
+// Rows returns the number of rows this journal wrote since it was created.
+func (obj *InsertJournal) Rows() int64 {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	return obj.rows
+}
+
+// LostRows returns the number of rows this journal failed to write
+// since it was created. They were inserted, so the journal is missing
+// rows the warehouse holds.
+func (obj *InsertJournal) LostRows() int64 {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	return obj.lostRows
+}
+
+// Empty reports whether the journal holds no files, including those
+// of an earlier run.
+func (obj *InsertJournal) Empty() (bool, error) {
+	matches, err := filepath.Glob(obj.Pattern())
+	if err != nil {
+		return false, errs.NewStackError(err)
+	}
+	return len(matches) == 0, nil
+}
+
+// Reset removes every file of the journal.
+func (obj *InsertJournal) Reset() error {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+
+	err := os.RemoveAll(obj.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errs.NewStackError(err)
+	}
+	err = os.MkdirAll(obj.dir, 0o755)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+	obj.records, obj.rows, obj.lostRows = 0, 0, 0
+	return nil
+}
+
+// Write adds the record to the journal. The rows of a record which
+// could not be written are counted by LostRows.
+func (obj *InsertJournal) Write(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) error {
+	err := obj.write(ctx, mem, record)
+	if err != nil {
+		obj.mu.Lock()
+		obj.lostRows += record.NumRows()
+		obj.mu.Unlock()
+	}
+	return err
+}
+
+func (obj *InsertJournal) write(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) error {
+	obj.mu.Lock()
+	seq := obj.records
+	obj.records++
+	obj.mu.Unlock()
+
+	// the file only gets its parquet name once it is complete
+	path := filepath.Join(obj.dir, fmt.Sprintf("%d-%d.parquet", time.Now().UnixNano(), seq))
+	err := arrowops.WriteRecordToParquetFile(ctx, mem, record, path+".tmp")
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return errs.Wrap(err, fmt.Errorf("insert journal %s", path))
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+
+	obj.mu.Lock()
+	obj.rows += record.NumRows()
+	obj.mu.Unlock()
+	return nil
+}
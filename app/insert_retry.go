@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	arrowops "github.com/alekLukanen/arrow-ops"
+	"github.com/alekLukanen/errs"
+)
+
+/*
+How often and how patiently a failed insert is retried. The wait
+before attempt n+1 is InitialBackoff * Multiplier^(n-1), capped at
+MaxBackoff, with up to Jitter of it (a fraction) taken off at random
+so that concurrent inserters do not retry in lockstep.
+*/
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+func (obj RetryPolicy) Validate() error {
+	problems := make([]string, 0)
+	if obj.MaxAttempts <= 0 {
+		problems = append(problems, "maxAttempts must be greater than zero")
+	}
+	if obj.InitialBackoff < 0 || obj.MaxBackoff < obj.InitialBackoff {
+		problems = append(problems, "initialBackoff must not be negative or greater than maxBackoff")
+	}
+	if obj.Multiplier < 1 {
+		problems = append(problems, "multiplier must be at least 1")
+	}
+	if obj.Jitter < 0 || obj.Jitter > 1 {
+		problems = append(problems, "jitter must be between 0 and 1")
+	}
+
+	if len(problems) > 0 {
+		return errs.NewStackError(fmt.Errorf("%w| %s", ErrInvalidRetryPolicy, strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+// Backoff returns the wait after the given failed attempt, counting from 1.
+func (obj RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(obj.InitialBackoff)
+	for i := 1; i < attempt && backoff < float64(obj.MaxBackoff); i++ {
+		backoff *= obj.Multiplier
+	}
+	backoff = min(backoff, float64(obj.MaxBackoff))
+	backoff -= backoff * obj.Jitter * rand.Float64()
+	return time.Duration(backoff)
+}
+
+// Errors which retrying an insert can't fix, since the record itself
+// does not fit the table and every attempt would fail the same way.
+var permanentInsertErrors = []error{
+	arrowops.ErrSchemasNotEqual,
+	arrowops.ErrDataTypesNotEqual,
+	arrowops.ErrColumnNotFound,
+	arrowops.ErrUnsupportedDataType,
+	arrowops.ErrNullValuesNotAllowed,
+	arrowops.ErrRecordNotComplete,
+	ErrUnsupportedPartition,
+	ErrPartitionValueOutOfRange,
+}
+
+// IsRetryableInsertError reports whether another attempt of a failed
+// insert can succeed.
+func IsRetryableInsertError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, permanentErr := range permanentInsertErrors {
+		if errors.Is(err, permanentErr) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Calls insert until it succeeds, the policy runs out of attempts or
+ctx is cancelled. The library does not type the errors of KeyDB or a
+held partition lock, so an error is treated as transient unless
+IsRetryableInsertError knows it can't be retried. The returned error
+wraps the last insert error, and ErrInsertNotRetryable when it could
+not be retried or ErrInsertRetriesExhausted once every attempt failed.
+A cancelled context is returned as is.
+*/
+func RetryInsert(
+	ctx context.Context,
+	logger *slog.Logger,
+	policy RetryPolicy,
+	insert func(ctx context.Context) error,
+) error {
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		err = insert(ctx)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		if !IsRetryableInsertError(err) {
+			return errs.Wrap(err, fmt.Errorf("%w| on attempt %d", ErrInsertNotRetryable, attempt))
+		}
+		if attempt == policy.MaxAttempts {
+			break
+		}
+
+		backoff := policy.Backoff(attempt)
+		insertRetries.Inc()
+		logger.Warn(
+			"insert failed; retrying",
+			slog.Int("attempt", attempt),
+			slog.Duration("backoff", backoff),
+			slog.String("error", err.Error()),
+		)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errs.Wrap(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	return errs.Wrap(err, fmt.Errorf("%w| after %d attempts", ErrInsertRetriesExhausted, policy.MaxAttempts))
+}
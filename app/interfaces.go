@@ -1,6 +1,8 @@
 package app
 
 import (
+	"context"
+
 	"github.com/apache/arrow/go/v17/arrow"
 	"github.com/apache/arrow/go/v17/arrow/memory"
 )
@@ -9,3 +11,28 @@ type Dataset interface {
 	Done() bool
 	BuildRecord(mem *memory.GoAllocator) arrow.Record
 }
+
+/*
+A dataset whose position can be saved and restored. Restoring a
+checkpoint into a dataset built with the same columns and params
+continues with exactly the records the checkpointed dataset would
+have produced next.
+*/
+type ResumableDataset interface {
+	Checkpoint() (*DatasetCheckpoint, error)
+	Restore(checkpoint *DatasetCheckpoint) error
+}
+
+/*
+A dataset which produces records on demand. Next returns io.EOF
+once the dataset has no more records; any other error means the
+dataset can not continue. Records are allocated from mem and owned
+by the caller. Close releases whatever the dataset holds open, such
+as files or connections. Use StreamDataset to read a Dataset through
+this interface.
+*/
+type StreamingDataset interface {
+	Schema() *arrow.Schema
+	Next(ctx context.Context, mem memory.Allocator) (arrow.Record, error)
+	Close() error
+}
@@ -0,0 +1,259 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+const loadPacingInterval = 10 * time.Millisecond
+
+/*
+Describes how fast the load generator sends rows. The target rate
+rises linearly from zero over RampUp, holds at RowsPerSecond and, when
+Duration is set, falls linearly back to zero over the final RampDown.
+Every BurstInterval the rate is multiplied by BurstMultiplier for
+BurstDuration. A RowsPerSecond of zero sends as fast as the inserters
+accept records. The run ends when Duration passes, the dataset has no
+more records or the context is cancelled.
+*/
+type LoadProfile struct {
+	RowsPerSecond float64
+	Concurrency   int
+	RampUp        time.Duration
+	RampDown      time.Duration
+	Duration      time.Duration
+
+	BurstInterval   time.Duration
+	BurstDuration   time.Duration
+	BurstMultiplier float64
+}
+
+func (obj LoadProfile) Validate() error {
+	problems := make([]string, 0)
+	if obj.RowsPerSecond < 0 {
+		problems = append(problems, "rowsPerSecond must not be negative")
+	}
+	if obj.Concurrency <= 0 {
+		problems = append(problems, "concurrency must be greater than zero")
+	}
+	if obj.RampUp < 0 || obj.RampDown < 0 || obj.Duration < 0 {
+		problems = append(problems, "rampUp, rampDown and duration must not be negative")
+	}
+	if obj.RampDown > 0 && obj.Duration == 0 {
+		problems = append(problems, "rampDown requires a duration")
+	}
+	if obj.Duration > 0 && obj.RampUp+obj.RampDown > obj.Duration {
+		problems = append(problems, "rampUp and rampDown must fit in the duration")
+	}
+	if obj.BurstInterval < 0 || obj.BurstDuration < 0 {
+		problems = append(problems, "burstInterval and burstDuration must not be negative")
+	}
+	if obj.BurstInterval > 0 {
+		if obj.BurstDuration == 0 || obj.BurstDuration >= obj.BurstInterval {
+			problems = append(problems, "burstDuration must be greater than zero and shorter than burstInterval")
+		}
+		if obj.BurstMultiplier <= 0 {
+			problems = append(problems, "burstMultiplier must be greater than zero")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errs.NewStackError(fmt.Errorf("%w| %s", ErrInvalidLoadProfile, strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+// RateAt returns the target rows per second at elapsed time into the run.
+func (obj LoadProfile) RateAt(elapsed time.Duration) float64 {
+	rate := obj.RowsPerSecond
+	if obj.RampUp > 0 && elapsed < obj.RampUp {
+		rate *= float64(elapsed) / float64(obj.RampUp)
+	}
+	if obj.RampDown > 0 && obj.Duration > 0 && elapsed > obj.Duration-obj.RampDown {
+		rate *= max(float64(obj.Duration-elapsed), 0) / float64(obj.RampDown)
+	}
+	if obj.BurstInterval > 0 && elapsed%obj.BurstInterval < obj.BurstDuration {
+		rate *= obj.BurstMultiplier
+	}
+	return rate
+}
+
+// LoadInsertFunc inserts one record; worker identifies the calling inserter.
+type LoadInsertFunc func(ctx context.Context, worker int, record arrow.Record) error
+
+type LoadReport struct {
+	Duration time.Duration
+	Records  int64
+	Rows     int64
+	Errors   int64
+
+	RowsPerSecond float64
+	LatencyP50    time.Duration
+	LatencyP90    time.Duration
+	LatencyP99    time.Duration
+	LatencyMax    time.Duration
+}
+
+func (obj *LoadReport) LogAttrs() []any {
+	return []any{
+		slog.Duration("duration", obj.Duration),
+		slog.Int64("records", obj.Records),
+		slog.Int64("rows", obj.Rows),
+		slog.Int64("errors", obj.Errors),
+		slog.Float64("rowsPerSecond", math.Round(obj.RowsPerSecond*100)/100),
+		slog.Duration("latencyP50", obj.LatencyP50),
+		slog.Duration("latencyP90", obj.LatencyP90),
+		slog.Duration("latencyP99", obj.LatencyP99),
+		slog.Duration("latencyMax", obj.LatencyMax),
+	}
+}
+
+/*
+Sends the records of the dataset to profile.Concurrency inserters at
+the rate of the profile. A single producer reads and paces the
+records since datasets are not safe for concurrent use; the rate is
+tracked in rows, so records of any size keep the average on target.
+Failed inserts are counted and logged but do not stop the run. The
+returned error is only set when the dataset fails.
+*/
+func RunLoad(
+	ctx context.Context,
+	logger *slog.Logger,
+	dataset StreamingDataset,
+	mem memory.Allocator,
+	profile LoadProfile,
+	insert LoadInsertFunc,
+) (*LoadReport, error) {
+	err := profile.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	runCtx := ctx
+	if profile.Duration > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, profile.Duration)
+		defer cancel()
+	}
+
+	records := make(chan arrow.Record, profile.Concurrency)
+	var (
+		mu        sync.Mutex
+		latencies = make([]time.Duration, 0, 1024)
+		report    = &LoadReport{}
+		wg        sync.WaitGroup
+	)
+
+	for worker := 0; worker < profile.Concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rec := range records {
+				start := time.Now()
+				// inserts already in flight finish even when the run ends
+				insertErr := insert(context.WithoutCancel(runCtx), worker, rec)
+				latency := time.Since(start)
+
+				mu.Lock()
+				report.Records++
+				if insertErr != nil {
+					report.Errors++
+				} else {
+					report.Rows += rec.NumRows()
+					latencies = append(latencies, latency)
+				}
+				mu.Unlock()
+
+				if insertErr != nil {
+					logger.Error("failed to insert tuple", slog.Int("worker", worker), slog.String("error", insertErr.Error()))
+				}
+				rec.Release()
+			}
+		}()
+	}
+
+	start := time.Now()
+	produceErr := produceLoad(runCtx, dataset, mem, profile, start, records)
+	close(records)
+	wg.Wait()
+
+	report.Duration = time.Since(start)
+	if report.Duration > 0 {
+		report.RowsPerSecond = float64(report.Rows) / report.Duration.Seconds()
+	}
+	slices.Sort(latencies)
+	report.LatencyP50 = latencyPercentile(latencies, 0.50)
+	report.LatencyP90 = latencyPercentile(latencies, 0.90)
+	report.LatencyP99 = latencyPercentile(latencies, 0.99)
+	report.LatencyMax = latencyPercentile(latencies, 1)
+
+	return report, produceErr
+}
+
+// produceLoad reads records from the dataset and sends them once the
+// profile's rate has accrued enough credit for their rows.
+func produceLoad(
+	ctx context.Context,
+	dataset StreamingDataset,
+	mem memory.Allocator,
+	profile LoadProfile,
+	start time.Time,
+	records chan<- arrow.Record,
+) error {
+	ticker := time.NewTicker(loadPacingInterval)
+	defer ticker.Stop()
+
+	credit := 0.0
+	lastTick := start
+	for {
+		rec, err := dataset.Next(ctx, mem)
+		if errors.Is(err, io.EOF) || (err != nil && ctx.Err() != nil) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// a record larger than a second of rows is sent once the
+		// credit is positive and the debt paid off by later ticks
+		for profile.RowsPerSecond > 0 && credit <= 0 {
+			select {
+			case <-ctx.Done():
+				rec.Release()
+				return nil
+			case now := <-ticker.C:
+				rate := profile.RateAt(now.Sub(start))
+				credit += rate * now.Sub(lastTick).Seconds()
+				credit = min(credit, max(rate, float64(rec.NumRows())))
+				lastTick = now
+			}
+		}
+		credit -= float64(rec.NumRows())
+
+		select {
+		case <-ctx.Done():
+			rec.Release()
+			return nil
+		case records <- rec:
+		}
+	}
+}
+
+func latencyPercentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[min(max(idx, 0), len(sorted)-1)]
+}
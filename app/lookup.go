@@ -0,0 +1,375 @@
+package app
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/ChapterhouseDB-v1/operations"
+	arrowops "github.com/alekLukanen/arrow-ops"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/apache/arrow/go/v17/parquet/file"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+/*
+Looks up the current rows of a table by the values of its first
+partition column, which is the key the tables are deduplicated on.
+Each key is mapped to its partition with the table's partition
+function, so a lookup only reads the part files the newest manifests
+of those partitions reference:
+
+	lookup := app.NewTableLookup(logger, tableRegistry, app.NewManifestReader(cfg), app.NewPartitionFileCache(256<<20))
+	rec, err := lookup.Lookup(ctx, mem, "table1", keys)
+
+The cache is optional; without it every lookup downloads its files.
+*/
+type TableLookup struct {
+	logger        *slog.Logger
+	tableRegistry *operations.TableRegistry
+	manifests     *ManifestReader
+	cache         *PartitionFileCache
+}
+
+func NewTableLookup(
+	logger *slog.Logger,
+	tableRegistry *operations.TableRegistry,
+	manifests *ManifestReader,
+	cache *PartitionFileCache,
+) *TableLookup {
+	return &TableLookup{
+		logger:        logger,
+		tableRegistry: tableRegistry,
+		manifests:     manifests,
+		cache:         cache,
+	}
+}
+
+/*
+Returns the rows of the table whose key is one of keys, in the order
+of their partitions and files. Keys are compared by their string
+value, so an Int64 array can look up an Int32 column. Keys without a
+row are left out. The result always has the columns of the table as
+nullable fields, whether or not any rows were found; a part file
+whose columns differ from the table's is an error.
+*/
+func (obj *TableLookup) Lookup(ctx context.Context, mem memory.Allocator, tableName string, keys arrow.Array) (arrow.Record, error) {
+	table, err := obj.tableRegistry.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(table.ColumnPartitions()) == 0 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| table %s has no partition column to look up by", ErrUnsupportedPartition, tableName))
+	}
+	partition := table.ColumnPartitions()[0]
+
+	partitionKeys, partitionOrder, err := groupKeysByPartition(keys, partition)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]arrow.Record, 0)
+	defer func() {
+		for _, rec := range found {
+			rec.Release()
+		}
+	}()
+	partitionFiles, err := obj.manifests.PartitionFiles(ctx, tableName, partitionOrder)
+	if err != nil {
+		return nil, err
+	}
+	fileCount := 0
+	for _, partitionKey := range partitionOrder {
+		fileCount += len(partitionFiles[partitionKey])
+		for _, path := range partitionFiles[partitionKey] {
+			records, err := obj.lookupFile(ctx, mem, path, partition, partitionKey, partitionKeys[partitionKey])
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, records...)
+		}
+	}
+
+	obj.logger.Debug(
+		"looked up keys",
+		slog.String("table", tableName),
+		slog.Int("keys", keys.Len()),
+		slog.Int("partitions", len(partitionOrder)),
+		slog.Int("files", fileCount),
+	)
+
+	return concatenateRecords(mem, tableSchema(table), found)
+}
+
+// groupKeysByPartition returns the string values of the keys by their
+// partition key, and the partition keys in the order of their first
+// key. Null keys are skipped.
+func groupKeysByPartition(keys arrow.Array, partition *elements.ColumnPartition) (map[string]map[string]bool, []string, error) {
+	partitionKeys := make(map[string]map[string]bool)
+	partitionOrder := make([]string, 0)
+	for i := 0; i < keys.Len(); i++ {
+		if keys.IsNull(i) {
+			continue
+		}
+		partitionKey, err := PartitionKey(keys, i, partition)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := partitionKeys[partitionKey]; !ok {
+			partitionKeys[partitionKey] = make(map[string]bool)
+			partitionOrder = append(partitionOrder, partitionKey)
+		}
+		partitionKeys[partitionKey][keys.ValueStr(i)] = true
+	}
+	return partitionKeys, partitionOrder, nil
+}
+
+/*
+Returns the rows of the part file whose key column value is in keys.
+The file must be in the partition the keys were mapped to, so a
+partition function which doesn't match the warehouse's fails with
+ErrPartitionLayoutMismatch instead of quietly missing rows.
+*/
+func (obj *TableLookup) lookupFile(
+	ctx context.Context,
+	mem memory.Allocator,
+	path string,
+	partition *elements.ColumnPartition,
+	partitionKey string,
+	keys map[string]bool,
+) ([]arrow.Record, error) {
+	data, err := obj.readFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	keyColumn := partition.Name()
+
+	parquetReader, err := file.NewParquetReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("part file %s", path))
+	}
+	defer parquetReader.Close()
+	_, err = verifyFilePartition(parquetReader.MetaData(), path, partition, partitionKey)
+	if err != nil {
+		return nil, err
+	}
+	arrowReader, err := pqarrow.NewFileReader(parquetReader, pqarrow.ArrowReadProperties{BatchSize: 1 << 16}, mem)
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+	recordReader, err := arrowReader.GetRecordReader(ctx, nil, nil)
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+	defer recordReader.Release()
+
+	records := make([]arrow.Record, 0)
+	for recordReader.Next() {
+		rec := recordReader.Record()
+		colIdx := rec.Schema().FieldIndices(keyColumn)
+		if len(colIdx) == 0 {
+			releaseRecords(records)
+			return nil, errs.NewStackError(fmt.Errorf("%w| column %s in part file %s", arrowops.ErrColumnNotFound, keyColumn, path))
+		}
+		column := rec.Column(colIdx[0])
+
+		indices := make([]uint32, 0)
+		for i := 0; i < column.Len(); i++ {
+			if !column.IsNull(i) && keys[column.ValueStr(i)] {
+				indices = append(indices, uint32(i))
+			}
+		}
+		if len(indices) == 0 {
+			continue
+		}
+
+		taken, err := takeRows(ctx, mem, rec, indices)
+		if err != nil {
+			releaseRecords(records)
+			return nil, err
+		}
+		records = append(records, taken)
+	}
+	// the reader reports io.EOF once every record was read
+	if err := recordReader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		releaseRecords(records)
+		return nil, errs.NewStackError(err)
+	}
+	return records, nil
+}
+
+func (obj *TableLookup) readFile(ctx context.Context, path string) ([]byte, error) {
+	if obj.cache != nil {
+		if data, ok := obj.cache.Get(path); ok {
+			lookupCacheRequests.WithLabelValues("hit").Inc()
+			return data, nil
+		}
+		lookupCacheRequests.WithLabelValues("miss").Inc()
+	}
+
+	data, err := obj.manifests.Download(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if obj.cache != nil {
+		obj.cache.Add(path, data)
+	}
+	return data, nil
+}
+
+func tableSchema(table *elements.Table) *arrow.Schema {
+	fields := make([]arrow.Field, len(table.Columns()))
+	for i, col := range table.Columns() {
+		fields[i] = arrow.Field{Name: col.Name, Type: col.Dtype, Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// concatenateRecords concatenates the records column by column into a
+// record of schema. The records must have the columns of schema, by
+// name and type, but may differ in nullability and metadata. Without
+// records the result is empty.
+func concatenateRecords(mem memory.Allocator, schema *arrow.Schema, records []arrow.Record) (arrow.Record, error) {
+	if len(records) == 0 {
+		builder := array.NewRecordBuilder(mem, schema)
+		defer builder.Release()
+		return builder.NewRecord(), nil
+	}
+
+	rows := int64(0)
+	for _, rec := range records {
+		if !sameColumns(schema, rec.Schema()) {
+			return nil, errs.NewStackError(fmt.Errorf("%w| %s and %s", arrowops.ErrSchemasNotEqual, schema, rec.Schema()))
+		}
+		rows += rec.NumRows()
+	}
+
+	columns := make([]arrow.Array, schema.NumFields())
+	defer func() {
+		for _, column := range columns {
+			if column != nil {
+				column.Release()
+			}
+		}
+	}()
+	for i := range columns {
+		parts := make([]arrow.Array, len(records))
+		for j, rec := range records {
+			parts[j] = rec.Column(i)
+		}
+		column, err := array.Concatenate(parts, mem)
+		if err != nil {
+			return nil, errs.Wrap(errs.NewStackError(err), fmt.Errorf("concatenating column %s", schema.Field(i).Name))
+		}
+		columns[i] = column
+	}
+	return array.NewRecord(schema, columns, rows), nil
+}
+
+// sameColumns reports whether the schemas have the same column names
+// and types in the same order.
+func sameColumns(a *arrow.Schema, b *arrow.Schema) bool {
+	if a.NumFields() != b.NumFields() {
+		return false
+	}
+	for i := 0; i < a.NumFields(); i++ {
+		if a.Field(i).Name != b.Field(i).Name || !arrow.TypeEqual(a.Field(i).Type, b.Field(i).Type) {
+			return false
+		}
+	}
+	return true
+}
+
+func releaseRecords(records []arrow.Record) {
+	for _, rec := range records {
+		rec.Release()
+	}
+}
+
+/*
+An LRU cache of the part files read by lookups, bounded by their
+total size in bytes. The warehouse writes each version of a partition
+to new files, so a cached file never goes stale; files of superseded
+versions are simply no longer asked for and age out.
+*/
+type PartitionFileCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	bytes   int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cachedPartitionFile struct {
+	path string
+	data []byte
+}
+
+func NewPartitionFileCache(maxBytes int64) *PartitionFileCache {
+	return &PartitionFileCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (obj *PartitionFileCache) Get(path string) ([]byte, bool) {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+
+	element, ok := obj.entries[path]
+	if !ok {
+		return nil, false
+	}
+	obj.order.MoveToFront(element)
+	return element.Value.(*cachedPartitionFile).data, true
+}
+
+// Add caches the file, evicting the least recently used files to stay
+// within the size limit. A file larger than the limit is not cached.
+func (obj *PartitionFileCache) Add(path string, data []byte) {
+	size := int64(len(data))
+	if size > obj.maxBytes {
+		return
+	}
+
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+
+	if element, ok := obj.entries[path]; ok {
+		obj.order.MoveToFront(element)
+		return
+	}
+	for obj.bytes+size > obj.maxBytes {
+		oldest := obj.order.Back()
+		cached := obj.order.Remove(oldest).(*cachedPartitionFile)
+		delete(obj.entries, cached.path)
+		obj.bytes -= int64(len(cached.data))
+	}
+	obj.entries[path] = obj.order.PushFront(&cachedPartitionFile{path: path, data: data})
+	obj.bytes += size
+}
+
+// Len returns the number of cached files.
+func (obj *PartitionFileCache) Len() int {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	return obj.order.Len()
+}
+
+// Bytes returns the total size of the cached files.
+func (obj *PartitionFileCache) Bytes() int64 {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	return obj.bytes
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	arrowops "github.com/alekLukanen/arrow-ops"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+func TestGroupKeysByPartition(t *testing.T) {
+	partition := testTable(t, "table1").ColumnPartitions()[0]
+	builder := array.NewInt64Builder(memory.NewGoAllocator())
+	defer builder.Release()
+	builder.AppendValues([]int64{5, 1500, 7, 0, 1999, -3, 5}, []bool{true, true, true, false, true, true, true})
+	keys := builder.NewArray()
+	defer keys.Release()
+
+	partitionKeys, partitionOrder, err := groupKeysByPartition(keys, partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"0", "1", "-1"}; !reflect.DeepEqual(partitionOrder, want) {
+		t.Fatalf("partition order %v, want %v", partitionOrder, want)
+	}
+	want := map[string]map[string]bool{
+		"0":  {"5": true, "7": true},
+		"1":  {"1500": true, "1999": true},
+		"-1": {"-3": true},
+	}
+	if !reflect.DeepEqual(partitionKeys, want) {
+		t.Fatalf("partition keys %v, want %v", partitionKeys, want)
+	}
+}
+
+func TestPartitionFileCache(t *testing.T) {
+	cache := NewPartitionFileCache(10)
+	cache.Add("a", []byte("aaaa"))
+	cache.Add("b", []byte("bbbb"))
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("a is not cached")
+	}
+
+	// b is the least recently used file now
+	cache.Add("c", []byte("cccc"))
+	if _, ok := cache.Get("b"); ok {
+		t.Fatal("b was not evicted")
+	}
+	for _, path := range []string{"a", "c"} {
+		if _, ok := cache.Get(path); !ok {
+			t.Fatalf("%s was evicted", path)
+		}
+	}
+
+	cache.Add("a", []byte("aaaa"))
+	cache.Add("large", []byte("larger than 10"))
+	if _, ok := cache.Get("large"); ok {
+		t.Fatal("a file larger than the cache was cached")
+	}
+	if cache.Len() != 2 || cache.Bytes() != 8 {
+		t.Fatalf("the cache holds %d files of %d bytes, want 2 of 8", cache.Len(), cache.Bytes())
+	}
+}
+
+func TestLookupFile(t *testing.T) {
+	ctx := context.Background()
+	table := testTable(t, "table1")
+	partition := table.ColumnPartitions()[0]
+	const path = "chdb/table-state/part-data/table1/1/d_1_0.parquet"
+
+	cache := NewPartitionFileCache(1 << 20)
+	cache.Add(path, table1PartFile(t, []int32{1000, 1500, 1999}))
+	lookup := &TableLookup{logger: slog.Default(), cache: cache}
+
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	records, err := lookup.lookupFile(ctx, mem, path, partition, "1", map[string]bool{"1500": true, "1999": true, "2500": true})
+	if err != nil {
+		t.Fatalf("lookupFile: %v", err)
+	}
+	result, err := concatenateRecords(mem, tableSchema(table), records)
+	releaseRecords(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Release()
+	if !result.Schema().Equal(tableSchema(table)) {
+		t.Fatalf("schema %s, want %s", result.Schema(), tableSchema(table))
+	}
+	ids := result.Column(0).(*array.Int32)
+	if ids.Len() != 2 || ids.Value(0) != 1500 || ids.Value(1) != 1999 {
+		t.Fatalf("found %s", ids)
+	}
+
+	_, err = lookup.lookupFile(ctx, mem, path, partition, "2", map[string]bool{"2500": true})
+	if !errors.Is(err, ErrPartitionLayoutMismatch) {
+		t.Fatalf("lookupFile in the wrong partition: got %v, want ErrPartitionLayoutMismatch", err)
+	}
+}
+
+func TestConcatenateRecordsSchema(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	schema := tableSchema(testTable(t, "table1"))
+
+	empty, err := concatenateRecords(mem, schema, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !empty.Schema().Equal(schema) || empty.NumRows() != 0 {
+		t.Fatalf("empty result has schema %s and %d rows", empty.Schema(), empty.NumRows())
+	}
+	empty.Release()
+
+	other := arrow.NewSchema([]arrow.Field{{Name: "column1", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	builder := array.NewRecordBuilder(mem, other)
+	defer builder.Release()
+	builder.Field(0).(*array.Int64Builder).Append(1)
+	rec := builder.NewRecord()
+	defer rec.Release()
+	_, err = concatenateRecords(mem, schema, []arrow.Record{rec})
+	if !errors.Is(err, arrowops.ErrSchemasNotEqual) {
+		t.Fatalf("got %v, want ErrSchemasNotEqual", err)
+	}
+}
+
+// table1PartFile returns a parquet file with the columns of table1, none
+// of them nullable, and the ids as column1.
+func table1PartFile(t *testing.T, ids []int32) []byte {
+	t.Helper()
+	mem := memory.NewGoAllocator()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "column1", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "column2", Type: arrow.FixedWidthTypes.Boolean},
+		{Name: "column3", Type: arrow.PrimitiveTypes.Float64},
+	}, nil)
+	builder := array.NewRecordBuilder(mem, schema)
+	defer builder.Release()
+	for i, id := range ids {
+		builder.Field(0).(*array.Int32Builder).Append(id)
+		builder.Field(1).(*array.BooleanBuilder).Append(i%2 == 0)
+		builder.Field(2).(*array.Float64Builder).Append(float64(i))
+	}
+	record := builder.NewRecord()
+	defer record.Release()
+
+	var buf bytes.Buffer
+	writer, err := pqarrow.NewFileWriter(schema, &buf, nil, pqarrow.DefaultWriterProps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Write(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
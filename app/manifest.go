@@ -0,0 +1,397 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/storage"
+	"github.com/alekLukanen/errs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+/*
+PartitionManifest is the manifest the warehouse writes for each
+version of a partition, listing the part files which hold its rows.
+Only the fields this reader uses are decoded and other fields are
+ignored, so a library release which adds one keeps working. The
+fields it uses are checked: a missing partition key, a manifest
+without objects and objects outside the table's part-data prefix are
+errors, so a manifest format this reader doesn't know fails loudly
+instead of dropping partitions.
+*/
+type PartitionManifest struct {
+	Id           string                    `json:"Id"`
+	TableName    string                    `json:"TableName"`
+	PartitionKey string                    `json:"PartitionKey"`
+	Version      int                       `json:"Version"`
+	Objects      []PartitionManifestObject `json:"Objects"`
+}
+
+type PartitionManifestObject struct {
+	Path  string `json:"Path"`
+	Index int    `json:"Index"`
+}
+
+/*
+The part files of a table as its manifests describe them. Files are
+object keys in the manifest bucket, and Partitions holds the files of
+each partition key. Orphans are part files no current
+manifest references, such as those of superseded partition versions
+which were not cleaned up yet; missing files are referenced by a
+current manifest but do not exist.
+*/
+type TableFiles struct {
+	Bucket     string
+	Partitions map[string][]string
+	Files      []string
+	Orphans    []string
+	Missing    []string
+}
+
+// URLs returns the s3 URLs of the files, which is how DuckDB reads them.
+func (obj *TableFiles) URLs() []string {
+	urls := make([]string, len(obj.Files))
+	for i, file := range obj.Files {
+		urls[i] = fmt.Sprintf("s3://%s/%s", obj.Bucket, file)
+	}
+	return urls
+}
+
+// ReadParquet returns the DuckDB read_parquet call over the files,
+// with options such as "filename = true" appended.
+func (obj *TableFiles) ReadParquet(options ...string) string {
+	urls := make([]string, len(obj.Files))
+	for i, url := range obj.URLs() {
+		urls[i] = fmt.Sprintf("'%s'", strings.ReplaceAll(url, "'", "''"))
+	}
+	args := append([]string{fmt.Sprintf("[%s]", strings.Join(urls, ", "))}, options...)
+	return fmt.Sprintf("read_parquet(%s)", strings.Join(args, ", "))
+}
+
+// Prune returns the files of the partitions whose key matches.
+func (obj *TableFiles) Prune(match func(partitionKey string) bool) *TableFiles {
+	pruned := &TableFiles{
+		Bucket:     obj.Bucket,
+		Partitions: make(map[string][]string),
+		Orphans:    obj.Orphans,
+		Missing:    obj.Missing,
+	}
+	for partitionKey, files := range obj.Partitions {
+		if match(partitionKey) {
+			pruned.Partitions[partitionKey] = files
+			pruned.Files = append(pruned.Files, files...)
+		}
+	}
+	slices.Sort(pruned.Files)
+	return pruned
+}
+
+// ManifestReader resolves the current files of a table from the
+// manifests in object storage.
+type ManifestReader struct {
+	options  storage.ManifestStorageOptions
+	s3Client *s3.Client
+}
+
+func NewManifestReader(cfg *Config) *ManifestReader {
+	return &ManifestReader{
+		options:  cfg.ManifestStorageOptions(),
+		s3Client: newS3Client(cfg),
+	}
+}
+
+func (obj *ManifestReader) manifestPrefix(tableName string) string {
+	return path.Join(obj.options.KeyPrefix, "table-state", "manifests", tableName) + "/"
+}
+
+func (obj *ManifestReader) partDataPrefix(tableName string) string {
+	return path.Join(obj.options.KeyPrefix, "table-state", "part-data", tableName) + "/"
+}
+
+/*
+Returns the files referenced by the newest manifest of every partition
+of the table, along with the part files which are orphaned and the
+referenced files which are missing. A table without manifests has no
+files.
+*/
+func (obj *ManifestReader) TableFiles(ctx context.Context, tableName string) (*TableFiles, error) {
+	current, err := obj.currentManifests(ctx, tableName, obj.manifestPrefix(tableName))
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]struct{})
+	for _, manifest := range current {
+		for _, object := range manifest.Objects {
+			referenced[object.Path] = struct{}{}
+		}
+	}
+
+	partKeys, err := obj.listKeys(ctx, obj.partDataPrefix(tableName))
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]struct{}, len(partKeys))
+	files := &TableFiles{Bucket: obj.options.BucketName, Partitions: make(map[string][]string, len(current))}
+	for _, key := range partKeys {
+		existing[key] = struct{}{}
+		if _, ok := referenced[key]; !ok && strings.HasSuffix(key, ".parquet") {
+			files.Orphans = append(files.Orphans, key)
+		}
+	}
+	// part files without any manifest mean the manifests are not
+	// where this reader looks for them
+	if len(current) == 0 && len(files.Orphans) > 0 {
+		return nil, errs.NewStackError(fmt.Errorf(
+			"%w| table %s has %d part files but no manifests under %s",
+			ErrInvalidManifest, tableName, len(files.Orphans), obj.manifestPrefix(tableName),
+		))
+	}
+	for partitionKey, manifest := range current {
+		partitionFiles := make([]string, 0, len(manifest.Objects))
+		for _, object := range manifest.Objects {
+			if _, ok := existing[object.Path]; ok {
+				partitionFiles = append(partitionFiles, object.Path)
+			} else {
+				files.Missing = append(files.Missing, object.Path)
+			}
+		}
+		slices.Sort(partitionFiles)
+		files.Partitions[partitionKey] = partitionFiles
+		files.Files = append(files.Files, partitionFiles...)
+	}
+	slices.Sort(files.Files)
+	slices.Sort(files.Orphans)
+	slices.Sort(files.Missing)
+
+	return files, nil
+}
+
+/*
+Returns the files referenced by the newest manifest of each of the
+partitions of the table, ordered by their index. The manifests are
+resolved like TableFiles does. A partition without a manifest has no
+files, unless part files exist under its key, which means its manifest
+is not where this reader looks for it.
+*/
+func (obj *ManifestReader) PartitionFiles(ctx context.Context, tableName string, partitionKeys []string) (map[string][]string, error) {
+	current, err := obj.currentManifests(ctx, tableName, obj.manifestPrefix(tableName))
+	if err != nil {
+		return nil, err
+	}
+
+	partitionFiles := make(map[string][]string, len(partitionKeys))
+	for _, partitionKey := range partitionKeys {
+		manifest, ok := current[partitionKey]
+		if !ok {
+			partitionPrefix := obj.partDataPrefix(tableName) + partitionKey + "/"
+			partKeys, err := obj.listKeys(ctx, partitionPrefix)
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range partKeys {
+				if strings.HasSuffix(key, ".parquet") {
+					return nil, errs.NewStackError(fmt.Errorf(
+						"%w| partition %s of table %s has part files under %s but no manifest",
+						ErrInvalidManifest, partitionKey, tableName, partitionPrefix,
+					))
+				}
+			}
+			continue
+		}
+
+		objects := slices.Clone(manifest.Objects)
+		slices.SortFunc(objects, func(a, b PartitionManifestObject) int { return a.Index - b.Index })
+		files := make([]string, len(objects))
+		for i, object := range objects {
+			files[i] = object.Path
+		}
+		partitionFiles[partitionKey] = files
+	}
+	return partitionFiles, nil
+}
+
+// currentManifests returns the newest manifest of each partition of
+// the table with manifests under the prefix.
+func (obj *ManifestReader) currentManifests(ctx context.Context, tableName string, prefix string) (map[string]PartitionManifest, error) {
+	manifestKeys, err := obj.listKeys(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]PartitionManifest)
+	for _, key := range newestManifestKeys(prefix, manifestKeys) {
+		manifest, err := obj.readManifest(ctx, tableName, key)
+		if err != nil {
+			return nil, err
+		}
+		if existing, ok := current[manifest.PartitionKey]; ok && existing.Version >= manifest.Version {
+			continue
+		}
+		current[manifest.PartitionKey] = manifest
+	}
+	return current, nil
+}
+
+var manifestVersionPattern = regexp.MustCompile(`(\d+)\.json$`)
+
+/*
+Returns the manifest keys which need to be read to find the newest
+manifest of each partition, so that a poll doesn't download every
+historical version. Manifests in a directory of their own below the
+prefix are taken to be the versions of one partition, and only the
+key ending in the highest number is kept. A directory with a key
+that doesn't end in a number, and manifests directly under the
+prefix, are kept whole; their versions are compared after reading.
+*/
+func newestManifestKeys(prefix string, keys []string) []string {
+	type group struct {
+		keys    []string
+		newest  string
+		version int64
+		ordered bool
+	}
+	groups := make(map[string]*group)
+	dirs := make([]string, 0)
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		dir := path.Dir(key)
+		g, ok := groups[dir]
+		if !ok {
+			g = &group{version: -1, ordered: dir+"/" != prefix}
+			groups[dir] = g
+			dirs = append(dirs, dir)
+		}
+		g.keys = append(g.keys, key)
+
+		match := manifestVersionPattern.FindStringSubmatch(path.Base(key))
+		if match == nil {
+			g.ordered = false
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			g.ordered = false
+			continue
+		}
+		if version > g.version {
+			g.version, g.newest = version, key
+		}
+	}
+
+	newest := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		g := groups[dir]
+		if g.ordered {
+			newest = append(newest, g.newest)
+		} else {
+			newest = append(newest, g.keys...)
+		}
+	}
+	return newest
+}
+
+// Download returns the content of an object in the manifest bucket.
+func (obj *ManifestReader) Download(ctx context.Context, key string) ([]byte, error) {
+	resp, err := obj.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(obj.options.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("downloading %s", key))
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+	return data, nil
+}
+
+func (obj *ManifestReader) listKeys(ctx context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	paginator := s3.NewListObjectsV2Paginator(obj.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(obj.options.BucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("listing %s/%s", obj.options.BucketName, prefix))
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	return keys, nil
+}
+
+func (obj *ManifestReader) readManifest(ctx context.Context, tableName string, key string) (PartitionManifest, error) {
+	data, err := obj.Download(ctx, key)
+	if err != nil {
+		return PartitionManifest{}, err
+	}
+	manifest, err := decodeManifest(data, tableName, obj.partDataPrefix(tableName))
+	if err != nil {
+		return PartitionManifest{}, errs.Wrap(err, fmt.Errorf("manifest %s", key))
+	}
+	return manifest, nil
+}
+
+// decodeManifest decodes and checks a manifest of the table whose part
+// files are under partDataPrefix.
+func decodeManifest(data []byte, tableName string, partDataPrefix string) (PartitionManifest, error) {
+	var manifest PartitionManifest
+	invalid := func(format string, args ...any) (PartitionManifest, error) {
+		return PartitionManifest{}, errs.NewStackError(fmt.Errorf("%w| %s", ErrInvalidManifest, fmt.Sprintf(format, args...)))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&manifest)
+	if err != nil {
+		return invalid("%s", err)
+	}
+	if decoder.More() {
+		return invalid("unexpected data after the manifest")
+	}
+
+	if manifest.TableName != tableName {
+		return invalid("manifest of table %q, expected %q", manifest.TableName, tableName)
+	}
+	if manifest.PartitionKey == "" {
+		return invalid("the partition key is empty")
+	}
+	if len(manifest.Objects) == 0 {
+		return invalid("partition %s lists no objects", manifest.PartitionKey)
+	}
+	for _, object := range manifest.Objects {
+		if !strings.HasPrefix(object.Path, partDataPrefix) {
+			return invalid("object %q of partition %s is not under %s", object.Path, manifest.PartitionKey, partDataPrefix)
+		}
+	}
+	return manifest, nil
+}
+
+func newS3Client(cfg *Config) *s3.Client {
+	return s3.New(s3.Options{
+		Region:       cfg.ObjectStorage.Region,
+		BaseEndpoint: aws.String(cfg.ObjectStorage.Endpoint),
+		UsePathStyle: cfg.ObjectStorage.UsePathStyle,
+		Credentials: credentials.NewStaticCredentialsProvider(
+			cfg.ObjectStorage.AuthKey, cfg.ObjectStorage.AuthSecret, "",
+		),
+	})
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testPartDataPrefix = "chdb/table-state/part-data/table1/"
+
+func TestDecodeManifestFixture(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "manifests", "table1-partition-0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := decodeManifest(data, "table1", testPartDataPrefix)
+	if err != nil {
+		t.Fatalf("decodeManifest: %v", err)
+	}
+	if manifest.TableName != "table1" || manifest.PartitionKey != "0" || manifest.Version != 3 {
+		t.Fatalf("decoded %+v", manifest)
+	}
+	if len(manifest.Objects) != 2 || manifest.Objects[1].Path != testPartDataPrefix+"0/d_3_1.parquet" {
+		t.Fatalf("decoded objects %+v", manifest.Objects)
+	}
+}
+
+func TestDecodeManifestRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", `manifest`},
+		{"renamed object field", `{"TableName": "table1", "PartitionKey": "0", "Objects": [{"Key": "chdb/table-state/part-data/table1/0/a.parquet"}]}`},
+		{"trailing data", `{"TableName": "table1", "PartitionKey": "0", "Objects": [{"Path": "chdb/table-state/part-data/table1/0/a.parquet"}]} {}`},
+		{"other table", `{"TableName": "table2", "PartitionKey": "0", "Objects": [{"Path": "chdb/table-state/part-data/table1/0/a.parquet"}]}`},
+		{"empty partition key", `{"TableName": "table1", "PartitionKey": "", "Objects": [{"Path": "chdb/table-state/part-data/table1/0/a.parquet"}]}`},
+		{"missing partition key", `{"TableName": "table1", "Objects": [{"Path": "chdb/table-state/part-data/table1/0/a.parquet"}]}`},
+		{"no objects", `{"TableName": "table1", "PartitionKey": "0", "Objects": []}`},
+		{"missing objects", `{"TableName": "table1", "PartitionKey": "0"}`},
+		{"object outside the table", `{"TableName": "table1", "PartitionKey": "0", "Objects": [{"Path": "chdb/table-state/part-data/table2/0/a.parquet"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeManifest([]byte(tt.data), "table1", testPartDataPrefix)
+			if !errors.Is(err, ErrInvalidManifest) {
+				t.Fatalf("got %v, want ErrInvalidManifest", err)
+			}
+		})
+	}
+}
+
+func TestDecodeManifestIgnoresUnknownFields(t *testing.T) {
+	data := `{"TableName": "table1", "PartitionKey": "0", "Version": 2, "Created": "2024-01-01", ` +
+		`"Objects": [{"Path": "chdb/table-state/part-data/table1/0/a.parquet", "Size": 10}]}`
+	manifest, err := decodeManifest([]byte(data), "table1", testPartDataPrefix)
+	if err != nil {
+		t.Fatalf("decodeManifest: %v", err)
+	}
+	if manifest.Version != 2 || len(manifest.Objects) != 1 {
+		t.Fatalf("decoded %+v", manifest)
+	}
+}
+
+func TestNewestManifestKeys(t *testing.T) {
+	const prefix = "chdb/table-state/manifests/table1/"
+	tests := []struct {
+		name string
+		keys []string
+		want []string
+	}{
+		{
+			"highest version per partition",
+			[]string{prefix + "0/d_2.json", prefix + "0/d_10.json", prefix + "0/d_9.json", prefix + "1/d_1.json"},
+			[]string{prefix + "0/d_10.json", prefix + "1/d_1.json"},
+		},
+		{
+			"other objects are skipped",
+			[]string{prefix + "0/d_1.json", prefix + "0/d_1.json.tmp", prefix + "0/README"},
+			[]string{prefix + "0/d_1.json"},
+		},
+		{
+			"keys without a version are all read",
+			[]string{prefix + "0/a.json", prefix + "0/d_1.json"},
+			[]string{prefix + "0/a.json", prefix + "0/d_1.json"},
+		},
+		{
+			"manifests directly under the prefix are all read",
+			[]string{prefix + "0_1.json", prefix + "0_2.json", prefix + "1_1.json"},
+			[]string{prefix + "0_1.json", prefix + "0_2.json", prefix + "1_1.json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newestManifestKeys(prefix, tt.keys)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("newestManifestKeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
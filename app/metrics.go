@@ -0,0 +1,210 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsRegistry holds every metric exported by the worker and tester.
+var MetricsRegistry = prometheus.NewRegistry()
+
+var (
+	tuplesInsertedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chdb_tuples_inserted_total",
+			Help: "Rows inserted into the warehouse.",
+		},
+		[]string{"table", "source"},
+	)
+	transformerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "chdb_transformer_duration_seconds",
+			Help:    "Time spent in a subscription transformer.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		},
+		[]string{"table", "source"},
+	)
+	transformerRowsIn = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chdb_transformer_rows_in_total",
+			Help: "Rows passed to a subscription transformer.",
+		},
+		[]string{"table", "source"},
+	)
+	transformerRowsOut = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chdb_transformer_rows_out_total",
+			Help: "Rows returned by a subscription transformer.",
+		},
+		[]string{"table", "source"},
+	)
+	transformerErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chdb_transformer_errors_total",
+			Help: "Subscription transformer calls which returned an error.",
+		},
+		[]string{"table", "source"},
+	)
+	dedupRowsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chdb_dedup_rows_dropped_total",
+			Help: "Rows removed by deduplication steps.",
+		},
+		[]string{"pipeline"},
+	)
+	// stands in for records written per partition, which can't be
+	// exported here: the warehouse writes partitions inside
+	// ChapterhouseDB and reports no counts back. The output is counted
+	// before it is written, so this is the partition fan-out of each
+	// transformed batch, not the rows written to each partition.
+	transformerOutputPartitions = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "chdb_transformer_output_partitions",
+			Help:    "Distinct partitions in the output of a subscription transformer call.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{"table", "source"},
+	)
+	insertRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "chdb_insert_retries_total",
+			Help: "Failed inserts which were retried.",
+		},
+	)
+	deadLetterRows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chdb_dead_letter_rows_total",
+			Help: "Rows written to a dead-letter file after every insert attempt failed.",
+		},
+		[]string{"table"},
+	)
+	lookupCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chdb_lookup_cache_requests_total",
+			Help: "Part files requested from the lookup cache, by hit or miss.",
+		},
+		[]string{"result"},
+	)
+	queueLength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "chdb_queue_length",
+			Help: "Number of tasks waiting in a tasker queue.",
+		},
+		[]string{"queue"},
+	)
+)
+
+func init() {
+	MetricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		tuplesInsertedTotal,
+		transformerDuration,
+		transformerRowsIn,
+		transformerRowsOut,
+		transformerErrors,
+		dedupRowsDropped,
+		transformerOutputPartitions,
+		insertRetries,
+		deadLetterRows,
+		lookupCacheRequests,
+		queueLength,
+	)
+}
+
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(MetricsRegistry, promhttp.HandlerOpts{Registry: MetricsRegistry})
+}
+
+// ObserveTuplesInserted records a successful insert of rows into a table.
+func ObserveTuplesInserted(tableName, sourceName string, rows int64) {
+	tuplesInsertedTotal.WithLabelValues(tableName, sourceName).Add(float64(rows))
+}
+
+/*
+Wraps a subscription transformer so that its duration, rows in and
+out, errors and the number of partitions its output spans are
+recorded. Empty input records are not observed. Rows written per
+partition are not recorded, as the warehouse writes them.
+*/
+func InstrumentTransformer(
+	tableName string,
+	sourceName string,
+	partitions []*elements.ColumnPartition,
+	transformer Transformer,
+) Transformer {
+	return func(
+		ctx context.Context,
+		mem *memory.GoAllocator,
+		logger *slog.Logger,
+		record arrow.Record,
+	) (arrow.Record, error) {
+		if record.NumRows() == 0 {
+			return transformer(ctx, mem, logger, record)
+		}
+
+		start := time.Now()
+		outRec, err := transformer(ctx, mem, logger, record)
+		transformerDuration.WithLabelValues(tableName, sourceName).Observe(time.Since(start).Seconds())
+		transformerRowsIn.WithLabelValues(tableName, sourceName).Add(float64(record.NumRows()))
+		if err != nil {
+			transformerErrors.WithLabelValues(tableName, sourceName).Inc()
+			return nil, err
+		}
+		transformerRowsOut.WithLabelValues(tableName, sourceName).Add(float64(outRec.NumRows()))
+
+		if len(partitions) > 0 {
+			keys, keyErr := PartitionKeys(outRec, partitions[0])
+			if keyErr != nil {
+				logger.Warn("unable to compute partition metrics", slog.String("error", keyErr.Error()))
+			} else {
+				distinct := make(map[string]struct{}, 8)
+				for _, key := range keys {
+					distinct[key] = struct{}{}
+				}
+				transformerOutputPartitions.WithLabelValues(tableName, sourceName).Observe(float64(len(distinct)))
+			}
+		}
+
+		return outRec, nil
+	}
+}
+
+// SampleQueueLength polls the queue length every interval until ctx is done.
+func SampleQueueLength(
+	ctx context.Context,
+	logger *slog.Logger,
+	queueName string,
+	interval time.Duration,
+	sample func(ctx context.Context) (float64, error),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ln, err := sample(ctx)
+		if err != nil {
+			logger.Warn(
+				"unable to sample the queue length",
+				slog.String("queue", queueName),
+				slog.String("error", err.Error()),
+			)
+		} else {
+			queueLength.WithLabelValues(queueName).Set(ln)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/apache/arrow/go/v17/parquet/metadata"
+	"github.com/apache/arrow/go/v17/parquet/schema"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+/*
+Checks that the partition keys computed by PartitionKey are the keys
+the warehouse wrote the partitions of the table under, before they are
+used to skip partitions. PartitionKey follows the warehouse's partition
+functions but is not them, so for up to sample partitions the smallest
+and the largest value of the partition column in the first file of the
+partition must both have the partition's key. A mismatch is
+ErrPartitionLayoutMismatch. Only the footers of the files are
+downloaded, as the values come from their column statistics.
+
+Returns how many partitions were checked. Files without statistics on
+the partition column can't be checked, so zero means the layout could
+not be verified and keys should not be trusted to prune.
+*/
+func VerifyPartitionLayout(
+	ctx context.Context,
+	manifests *ManifestReader,
+	partition *elements.ColumnPartition,
+	partitions map[string][]string,
+	sample int,
+) (int, error) {
+	partitionKeys := make([]string, 0, len(partitions))
+	for partitionKey, files := range partitions {
+		if len(files) > 0 {
+			partitionKeys = append(partitionKeys, partitionKey)
+		}
+	}
+	slices.Sort(partitionKeys)
+
+	verified := 0
+	for _, partitionKey := range partitionKeys {
+		if verified >= sample {
+			break
+		}
+		path := partitions[partitionKey][0]
+		md, err := manifests.ReadParquetMetadata(ctx, path)
+		if err != nil {
+			return verified, err
+		}
+		ok, err := verifyFilePartition(md, path, partition, partitionKey)
+		if err != nil {
+			return verified, err
+		}
+		if ok {
+			verified++
+		}
+	}
+	return verified, nil
+}
+
+// verifyFilePartition checks the statistics of the partition column in
+// the metadata of the part file against the key of the partition
+// holding the file. It returns false when the file has no statistics to
+// check.
+func verifyFilePartition(md *metadata.FileMetaData, path string, partition *elements.ColumnPartition, partitionKey string) (bool, error) {
+	colIdx := md.Schema.ColumnIndexByName(partition.Name())
+	if colIdx < 0 {
+		return false, nil
+	}
+	column := md.Schema.Column(colIdx)
+
+	verified := false
+	for rg := 0; rg < len(md.GetRowGroups()); rg++ {
+		chunk, err := md.RowGroup(rg).ColumnChunk(colIdx)
+		if err != nil {
+			return false, errs.NewStackError(err)
+		}
+		set, err := chunk.StatsSet()
+		if err != nil || !set {
+			continue
+		}
+		stats, err := chunk.Statistics()
+		if err != nil || stats == nil || !stats.HasMinMax() {
+			continue
+		}
+		bounds := statisticsBounds(stats, column)
+		if bounds == nil {
+			continue
+		}
+
+		for i := 0; i < bounds.Len(); i++ {
+			key, err := PartitionKey(bounds, i, partition)
+			if err != nil {
+				bounds.Release()
+				return false, err
+			}
+			if key != partitionKey {
+				value := bounds.ValueStr(i)
+				bounds.Release()
+				return false, errs.NewStackError(fmt.Errorf(
+					"%w| %s = %s in part file %s has key %s, but the file is in partition %s",
+					ErrPartitionLayoutMismatch, partition.Name(), value, path, key, partitionKey,
+				))
+			}
+		}
+		bounds.Release()
+		verified = true
+	}
+	return verified, nil
+}
+
+/*
+Reads the metadata of a parquet file in the manifest bucket with two
+ranged requests, one for the fixed size end of the file which gives
+the length of the metadata and one for the metadata itself, instead
+of downloading the whole file.
+*/
+func (obj *ManifestReader) ReadParquetMetadata(ctx context.Context, key string) (*metadata.FileMetaData, error) {
+	end, err := obj.downloadTail(ctx, key, parquetEndSize)
+	if err != nil {
+		return nil, err
+	}
+	size, err := parquetMetadataSize(end)
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("part file %s", key))
+	}
+	tail, err := obj.downloadTail(ctx, key, size+parquetEndSize)
+	if err != nil {
+		return nil, err
+	}
+	md, err := parquetMetadata(tail)
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("part file %s", key))
+	}
+	return md, nil
+}
+
+// downloadTail returns the last n bytes of an object in the manifest bucket.
+func (obj *ManifestReader) downloadTail(ctx context.Context, key string, n int64) ([]byte, error) {
+	resp, err := obj.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(obj.options.BucketName),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=-%d", n)),
+	})
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("downloading the last %d bytes of %s", n, key))
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+	return data, nil
+}
+
+// a parquet file ends in the length of its metadata and the magic bytes
+const parquetEndSize = 8
+
+var parquetMagic = []byte("PAR1")
+
+// parquetMetadataSize returns the length of the metadata of a parquet
+// file from the last bytes of the file.
+func parquetMetadataSize(tail []byte) (int64, error) {
+	if len(tail) < parquetEndSize {
+		return 0, errs.NewStackError(fmt.Errorf("the file is too small for parquet (%d bytes)", len(tail)))
+	}
+	end := tail[len(tail)-parquetEndSize:]
+	if !bytes.Equal(end[4:], parquetMagic) {
+		return 0, errs.NewStackError(fmt.Errorf("the file does not end in the magic bytes of unencrypted parquet"))
+	}
+	return int64(binary.LittleEndian.Uint32(end[:4])), nil
+}
+
+// parquetMetadata decodes the metadata of a parquet file from the last
+// bytes of the file, which must hold the whole metadata.
+func parquetMetadata(tail []byte) (*metadata.FileMetaData, error) {
+	size, err := parquetMetadataSize(tail)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(tail)) < size+parquetEndSize {
+		return nil, errs.NewStackError(fmt.Errorf("the metadata of %d bytes is cut short", size))
+	}
+	end := int64(len(tail)) - parquetEndSize
+	md, err := metadata.NewFileMetaData(tail[end-size:end], nil)
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+	return md, nil
+}
+
+// statisticsBounds returns the minimum and maximum of the statistics as
+// an array of the column's type, or nil for types which aren't
+// partitioned on.
+func statisticsBounds(stats metadata.TypedStatistics, column *schema.Column) arrow.Array {
+	unsigned := false
+	if logicalType, ok := column.LogicalType().(*schema.IntLogicalType); ok {
+		unsigned = !logicalType.IsSigned()
+	}
+
+	mem := memory.DefaultAllocator
+	switch s := stats.(type) {
+	case *metadata.Int32Statistics:
+		if unsigned {
+			builder := array.NewUint32Builder(mem)
+			defer builder.Release()
+			builder.AppendValues([]uint32{uint32(s.Min()), uint32(s.Max())}, nil)
+			return builder.NewArray()
+		}
+		builder := array.NewInt32Builder(mem)
+		defer builder.Release()
+		builder.AppendValues([]int32{s.Min(), s.Max()}, nil)
+		return builder.NewArray()
+	case *metadata.Int64Statistics:
+		if unsigned {
+			builder := array.NewUint64Builder(mem)
+			defer builder.Release()
+			builder.AppendValues([]uint64{uint64(s.Min()), uint64(s.Max())}, nil)
+			return builder.NewArray()
+		}
+		builder := array.NewInt64Builder(mem)
+		defer builder.Release()
+		builder.AppendValues([]int64{s.Min(), s.Max()}, nil)
+		return builder.NewArray()
+	case *metadata.ByteArrayStatistics:
+		builder := array.NewStringBuilder(mem)
+		defer builder.Release()
+		builder.AppendValues([]string{string(s.Min()), string(s.Max())}, nil)
+		return builder.NewArray()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,334 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/ChapterhouseDB-v1/partitionFuncs"
+	"github.com/alekLukanen/errs"
+)
+
+const (
+	PredicateEqual        = "="
+	PredicateIn           = "in"
+	PredicateBetween      = "between"
+	PredicateLess         = "<"
+	PredicateLessEqual    = "<="
+	PredicateGreater      = ">"
+	PredicateGreaterEqual = ">="
+)
+
+/*
+A simple predicate on the partition column of a table, written as
+
+	table1.column1 = 5
+	table1.column1 IN (5, 1200)
+	table1.column1 BETWEEN 1000 AND 2999
+	table2.column1 IN ('a', 'b')
+
+or with <, <=, > and >=. Values are integers or single quoted
+strings. The predicate decides which partition directories can hold
+matching rows, so a query only has to read their files.
+*/
+type PartitionPredicate struct {
+	Table    string
+	Column   string
+	Operator string
+	// int64 or string values, all of the same type
+	Values []any
+}
+
+func ParsePartitionPredicate(expr string) (PartitionPredicate, error) {
+	var predicate PartitionPredicate
+	fail := func(format string, args ...any) (PartitionPredicate, error) {
+		return PartitionPredicate{}, errs.NewStackError(
+			fmt.Errorf("%w| %q: %s", ErrInvalidPartitionPredicate, expr, fmt.Sprintf(format, args...)),
+		)
+	}
+
+	tokens, err := predicateTokens(expr)
+	if err != nil {
+		return fail("%s", err)
+	}
+	pos := 0
+	next := func() predicateToken {
+		if pos >= len(tokens) {
+			return predicateToken{}
+		}
+		pos++
+		return tokens[pos-1]
+	}
+	value := func() (any, bool) {
+		token := next()
+		return token.value, token.value != nil
+	}
+
+	table, dot, column := next(), next(), next()
+	if table.ident == "" || dot.text != "." || column.ident == "" {
+		return fail("expected table.column")
+	}
+	predicate.Table, predicate.Column = table.ident, column.ident
+
+	operator := next()
+	switch strings.ToLower(operator.text) {
+	case "=", "==":
+		predicate.Operator = PredicateEqual
+	case PredicateLess, PredicateLessEqual, PredicateGreater, PredicateGreaterEqual:
+		predicate.Operator = operator.text
+	case PredicateIn:
+		predicate.Operator = PredicateIn
+	case PredicateBetween:
+		predicate.Operator = PredicateBetween
+	default:
+		return fail("unsupported operator %q", operator.text)
+	}
+
+	switch predicate.Operator {
+	case PredicateIn:
+		if next().text != "(" {
+			return fail("expected ( after IN")
+		}
+		for {
+			v, ok := value()
+			if !ok {
+				return fail("expected a value in the IN list")
+			}
+			predicate.Values = append(predicate.Values, v)
+			separator := next()
+			if separator.text == ")" {
+				break
+			}
+			if separator.text != "," {
+				return fail("expected , or ) in the IN list")
+			}
+		}
+	case PredicateBetween:
+		low, ok := value()
+		if !ok || !strings.EqualFold(next().text, "and") {
+			return fail("expected BETWEEN <value> AND <value>")
+		}
+		high, ok := value()
+		if !ok {
+			return fail("expected BETWEEN <value> AND <value>")
+		}
+		predicate.Values = []any{low, high}
+	default:
+		v, ok := value()
+		if !ok {
+			return fail("expected a value after %s", predicate.Operator)
+		}
+		predicate.Values = []any{v}
+	}
+	if pos < len(tokens) {
+		return fail("unexpected %q", tokens[pos].text)
+	}
+
+	for _, v := range predicate.Values[1:] {
+		if fmt.Sprintf("%T", v) != fmt.Sprintf("%T", predicate.Values[0]) {
+			return fail("the values mix integers and strings")
+		}
+	}
+	return predicate, nil
+}
+
+// SQL returns the predicate as a DuckDB condition on the column.
+func (obj PartitionPredicate) SQL() string {
+	column := fmt.Sprintf(`"%s"`, obj.Column)
+	literals := make([]string, len(obj.Values))
+	for i, v := range obj.Values {
+		switch value := v.(type) {
+		case int64:
+			literals[i] = strconv.FormatInt(value, 10)
+		case string:
+			literals[i] = fmt.Sprintf("'%s'", strings.ReplaceAll(value, "'", "''"))
+		}
+	}
+
+	switch obj.Operator {
+	case PredicateIn:
+		return fmt.Sprintf("%s IN (%s)", column, strings.Join(literals, ", "))
+	case PredicateBetween:
+		return fmt.Sprintf("%s BETWEEN %s AND %s", column, literals[0], literals[1])
+	default:
+		return fmt.Sprintf("%s %s %s", column, obj.Operator, literals[0])
+	}
+}
+
+func (obj PartitionPredicate) String() string {
+	return fmt.Sprintf("%s.%s", obj.Table, obj.SQL())
+}
+
+/*
+Returns whether a partition key of the table can hold rows matching
+the predicate, using the options of the partition on the predicate's
+column. An integer range partition k holds [k*width, (k+1)*width), so
+every predicate prunes it. A string hash partition only prunes by
+equality and IN since hashing does not keep the order of the values.
+Keys which are not partition keys of the options always match.
+*/
+func (obj PartitionPredicate) Matcher(table *elements.Table) (func(partitionKey string) bool, error) {
+	var partition *elements.ColumnPartition
+	for _, part := range table.ColumnPartitions() {
+		if part.Name() == obj.Column {
+			partition = part
+		}
+	}
+	if partition == nil {
+		return nil, errs.NewStackError(
+			fmt.Errorf("%w| %s is not a partition column of table %s", ErrInvalidPartitionPredicate, obj.Column, table.TableName()),
+		)
+	}
+
+	// keys the options could not have produced are kept, like the range
+	// branch keeps keys which are not integers
+	keySet := func(key func(v any) string, valid func(partitionKey string) bool) func(string) bool {
+		keys := make(map[string]bool, len(obj.Values))
+		for _, v := range obj.Values {
+			keys[key(v)] = true
+		}
+		return func(partitionKey string) bool { return keys[partitionKey] || !valid(partitionKey) }
+	}
+
+	switch options := partition.Options().(type) {
+	case *partitionFuncs.IntegerRangePartitionOptions:
+		if _, ok := obj.Values[0].(int64); !ok {
+			return nil, errs.NewStackError(
+				fmt.Errorf("%w| %s is an integer range partition; use integer values", ErrInvalidPartitionPredicate, obj.Column),
+			)
+		}
+		width := int64(options.Width)
+		if obj.Operator == PredicateEqual || obj.Operator == PredicateIn {
+			return keySet(
+				func(v any) string { return IntegerRangePartitionKey(v.(int64), options.Width) },
+				func(partitionKey string) bool {
+					key, err := strconv.ParseInt(partitionKey, 10, 64)
+					return err == nil && strconv.FormatInt(key, 10) == partitionKey
+				},
+			), nil
+		}
+
+		low, high := obj.bounds()
+		return func(partitionKey string) bool {
+			key, err := strconv.ParseInt(partitionKey, 10, 64)
+			if err != nil {
+				return true
+			}
+			start := key * width
+			end := start + width - 1
+			return (high == nil || start <= *high) && (low == nil || end >= *low)
+		}, nil
+	case *partitionFuncs.StringHashPartitionOptions:
+		if _, ok := obj.Values[0].(string); !ok {
+			return nil, errs.NewStackError(
+				fmt.Errorf("%w| %s is a string hash partition; use quoted values", ErrInvalidPartitionPredicate, obj.Column),
+			)
+		}
+		if obj.Operator == PredicateEqual || obj.Operator == PredicateIn {
+			return keySet(
+				func(v any) string { return StringHashPartitionKey(v.(string), options.PartitionCount) },
+				func(partitionKey string) bool {
+					key, err := strconv.ParseUint(partitionKey, 10, 64)
+					return err == nil && strconv.FormatUint(key, 10) == partitionKey && key < uint64(options.PartitionCount)
+				},
+			), nil
+		}
+		return func(string) bool { return true }, nil
+	default:
+		return nil, errs.NewStackError(fmt.Errorf("%w| %T", ErrUnsupportedPartition, options))
+	}
+}
+
+// bounds returns the inclusive range of an integer range predicate,
+// nil on an unbounded side.
+func (obj PartitionPredicate) bounds() (*int64, *int64) {
+	value := obj.Values[0].(int64)
+	switch obj.Operator {
+	case PredicateBetween:
+		high := obj.Values[1].(int64)
+		return &value, &high
+	case PredicateLess:
+		value--
+		return nil, &value
+	case PredicateLessEqual:
+		return nil, &value
+	case PredicateGreater:
+		value++
+		return &value, nil
+	default:
+		return &value, nil
+	}
+}
+
+type predicateToken struct {
+	text  string
+	ident string
+	value any
+}
+
+func predicateTokens(expr string) ([]predicateToken, error) {
+	tokens := make([]predicateToken, 0)
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'':
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						sb.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, predicateToken{text: "'" + sb.String() + "'", value: sb.String()})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			text := string(runes[start:i])
+			value, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, predicateToken{text: text, value: value})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			text := string(runes[start:i])
+			tokens = append(tokens, predicateToken{text: text, ident: text})
+		case r == '<' || r == '>' || r == '=':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, predicateToken{text: string(runes[i : i+2])})
+				i += 2
+			} else {
+				tokens = append(tokens, predicateToken{text: string(r)})
+				i++
+			}
+		case strings.ContainsRune(".,()", r):
+			tokens = append(tokens, predicateToken{text: string(r)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q", r)
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,279 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/apache/arrow/go/v17/parquet/metadata"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+)
+
+func TestParsePartitionPredicate(t *testing.T) {
+	tests := []struct {
+		expr     string
+		operator string
+		values   []any
+	}{
+		{"table1.column1 = 5", PredicateEqual, []any{int64(5)}},
+		{"table1.column1 == 5", PredicateEqual, []any{int64(5)}},
+		{"table1.column1 = -5", PredicateEqual, []any{int64(-5)}},
+		{"table1.column1 < 2000", PredicateLess, []any{int64(2000)}},
+		{"table1.column1 <= -1", PredicateLessEqual, []any{int64(-1)}},
+		{"table1.column1 > 2999", PredicateGreater, []any{int64(2999)}},
+		{"table1.column1 >= -1000", PredicateGreaterEqual, []any{int64(-1000)}},
+		{"table1.column1 IN (5, -1200)", PredicateIn, []any{int64(5), int64(-1200)}},
+		{"table1.column1 between -10 AND 10", PredicateBetween, []any{int64(-10), int64(10)}},
+		{"table2.column1 = 'a'", PredicateEqual, []any{"a"}},
+		{"table2.column1 in ('it''s', '')", PredicateIn, []any{"it's", ""}},
+		{"table2.column1 = ''''", PredicateEqual, []any{"'"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			predicate, err := ParsePartitionPredicate(tt.expr)
+			if err != nil {
+				t.Fatalf("ParsePartitionPredicate: %v", err)
+			}
+			if predicate.Column != "column1" || predicate.Operator != tt.operator || !reflect.DeepEqual(predicate.Values, tt.values) {
+				t.Fatalf("parsed %+v, want operator %s and values %v", predicate, tt.operator, tt.values)
+			}
+		})
+	}
+}
+
+func TestParsePartitionPredicateRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"mixed types", "table1.column1 IN (5, 'a')"},
+		{"mixed between", "table1.column1 BETWEEN 'a' AND 5"},
+		{"no table", "column1 = 5"},
+		{"unsupported operator", "table1.column1 != 5"},
+		{"missing value", "table1.column1 ="},
+		{"trailing tokens", "table1.column1 = 5 6"},
+		{"unterminated string", "table2.column1 = 'a"},
+		{"unclosed IN", "table1.column1 IN (5, 6"},
+		{"BETWEEN without AND", "table1.column1 BETWEEN 5 OR 6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePartitionPredicate(tt.expr)
+			if !errors.Is(err, ErrInvalidPartitionPredicate) {
+				t.Fatalf("got %v, want ErrInvalidPartitionPredicate", err)
+			}
+		})
+	}
+}
+
+func TestPartitionPredicateSQL(t *testing.T) {
+	predicate, err := ParsePartitionPredicate("table2.column1 IN ('it''s', 'b')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql := predicate.SQL(); sql != `"column1" IN ('it''s', 'b')` {
+		t.Fatalf("SQL() = %s", sql)
+	}
+}
+
+func TestPartitionPredicateBounds(t *testing.T) {
+	tests := []struct {
+		expr      string
+		low, high *int64
+	}{
+		{"table1.column1 < 2000", nil, ptr(int64(1999))},
+		{"table1.column1 <= 2000", nil, ptr(int64(2000))},
+		{"table1.column1 > 2999", ptr(int64(3000)), nil},
+		{"table1.column1 >= 2999", ptr(int64(2999)), nil},
+		{"table1.column1 BETWEEN -5 AND 5", ptr(int64(-5)), ptr(int64(5))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			predicate, err := ParsePartitionPredicate(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			low, high := predicate.bounds()
+			if !reflect.DeepEqual(low, tt.low) || !reflect.DeepEqual(high, tt.high) {
+				t.Fatalf("bounds() = %v, %v, want %v, %v", deref(low), deref(high), deref(tt.low), deref(tt.high))
+			}
+		})
+	}
+}
+
+func TestPartitionPredicateMatcher(t *testing.T) {
+	aKey := StringHashPartitionKey("a", 10)
+	otherKey := "0"
+	if aKey == otherKey {
+		otherKey = "1"
+	}
+
+	tests := []struct {
+		expr    string
+		matches map[string]bool
+	}{
+		// table1 has integer range partitions of width 1000
+		{"table1.column1 = 5", map[string]bool{"0": true, "1": false, "-1": false}},
+		{"table1.column1 = -1", map[string]bool{"-1": true, "0": false}},
+		{"table1.column1 = -1000", map[string]bool{"-1": true, "-2": false}},
+		{"table1.column1 IN (5, 1200)", map[string]bool{"0": true, "1": true, "2": false}},
+		{"table1.column1 < 2000", map[string]bool{"1": true, "2": false}},
+		{"table1.column1 <= 2000", map[string]bool{"2": true, "3": false}},
+		{"table1.column1 > 2999", map[string]bool{"2": false, "3": true}},
+		{"table1.column1 >= 2999", map[string]bool{"1": false, "2": true}},
+		{"table1.column1 < -1000", map[string]bool{"-2": true, "-1": false}},
+		{"table1.column1 BETWEEN -1000 AND -1", map[string]bool{"-2": false, "-1": true, "0": false}},
+		// keys the options could not have produced are kept
+		{"table1.column1 = 5", map[string]bool{"x": true, "05": true, "+1": true}},
+		{"table1.column1 < 2000", map[string]bool{"x": true}},
+		// table2 has 10 string hash partitions
+		{"table2.column1 = 'a'", map[string]bool{aKey: true, otherKey: false}},
+		{"table2.column1 IN ('a')", map[string]bool{aKey: true, otherKey: false}},
+		{"table2.column1 = 'a'", map[string]bool{"10": true, "-1": true, "x": true}},
+		{"table2.column1 > 'a'", map[string]bool{aKey: true, otherKey: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			predicate, err := ParsePartitionPredicate(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			table := testTable(t, predicate.Table)
+
+			match, err := predicate.Matcher(table)
+			if err != nil {
+				t.Fatalf("Matcher: %v", err)
+			}
+			for partitionKey, want := range tt.matches {
+				if got := match(partitionKey); got != want {
+					t.Errorf("match(%q) = %v, want %v", partitionKey, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionPredicateMatcherRejects(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  error
+	}{
+		{"table1.column1 = 'a'", ErrInvalidPartitionPredicate},
+		{"table2.column1 = 5", ErrInvalidPartitionPredicate},
+		{"table1.column2 = 5", ErrInvalidPartitionPredicate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			predicate, err := ParsePartitionPredicate(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			table := testTable(t, predicate.Table)
+			_, err = predicate.Matcher(table)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyFilePartition(t *testing.T) {
+	partition := testTable(t, "table1").ColumnPartitions()[0]
+	md := partFileMetadata(t, partFile(t, []int32{1000, 1500, 1999}))
+
+	ok, err := verifyFilePartition(md, "d_1_0.parquet", partition, "1")
+	if err != nil || !ok {
+		t.Fatalf("verifyFilePartition in partition 1 = %v, %v, want true", ok, err)
+	}
+
+	_, err = verifyFilePartition(md, "d_1_0.parquet", partition, "2")
+	if !errors.Is(err, ErrPartitionLayoutMismatch) {
+		t.Fatalf("verifyFilePartition in partition 2: got %v, want ErrPartitionLayoutMismatch", err)
+	}
+
+	_, err = verifyFilePartition(partFileMetadata(t, partFile(t, []int32{999, 1000})), "d_1_0.parquet", partition, "1")
+	if !errors.Is(err, ErrPartitionLayoutMismatch) {
+		t.Fatalf("verifyFilePartition of a file spanning two partitions: got %v, want ErrPartitionLayoutMismatch", err)
+	}
+}
+
+// partFile returns a parquet file with the values as column1.
+func partFile(t *testing.T, values []int32) []byte {
+	t.Helper()
+	mem := memory.NewGoAllocator()
+	schema := arrow.NewSchema([]arrow.Field{{Name: "column1", Type: arrow.PrimitiveTypes.Int32}}, nil)
+	builder := array.NewRecordBuilder(mem, schema)
+	defer builder.Release()
+	builder.Field(0).(*array.Int32Builder).AppendValues(values, nil)
+	record := builder.NewRecord()
+	defer record.Release()
+
+	var buf bytes.Buffer
+	writer, err := pqarrow.NewFileWriter(schema, &buf, nil, pqarrow.DefaultWriterProps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Write(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParquetMetadata(t *testing.T) {
+	data := partFile(t, []int32{1, 2, 3})
+	size, err := parquetMetadataSize(data[len(data)-parquetEndSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tail holding exactly the metadata is all a ranged read fetches
+	md, err := parquetMetadata(data[len(data)-int(size)-parquetEndSize:])
+	if err != nil {
+		t.Fatalf("parquetMetadata: %v", err)
+	}
+	if md.GetNumRows() != 3 {
+		t.Fatalf("decoded %d rows, want 3", md.GetNumRows())
+	}
+
+	_, err = parquetMetadata(data[len(data)-int(size):])
+	if err == nil {
+		t.Fatal("parquetMetadata of a cut short tail succeeded")
+	}
+	_, err = parquetMetadataSize([]byte("not parquet"))
+	if err == nil {
+		t.Fatal("parquetMetadataSize of a file without the magic bytes succeeded")
+	}
+}
+
+// partFileMetadata returns the metadata of a parquet file.
+func partFileMetadata(t *testing.T, data []byte) *metadata.FileMetaData {
+	t.Helper()
+	md, err := parquetMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return md
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func deref(v *int64) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/ChapterhouseDB-v1/partitionFuncs"
+	arrowops "github.com/alekLukanen/arrow-ops"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+/*
+Returns the partition key of every row in the record for the column
+partition. The keys mirror the partition directory names the warehouse
+writes under table-state/part-data/<table>/<key>:
+
+  - integer range: floor(value / width)
+  - string hash: fnv32a(value) % partitionCount
+
+The functions are implemented here rather than called from
+partitionFuncs, so VerifyPartitionLayout and TableLookup check the
+keys against the part files the warehouse wrote before relying on
+them.
+*/
+func PartitionKeys(record arrow.Record, partition *elements.ColumnPartition) ([]string, error) {
+	colIdx := record.Schema().FieldIndices(partition.Name())
+	if len(colIdx) == 0 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| column name: %s", arrowops.ErrColumnNotFound, partition.Name()))
+	}
+	arr := record.Column(colIdx[0])
+
+	keys := make([]string, arr.Len())
+	for i := 0; i < arr.Len(); i++ {
+		if arr.IsNull(i) {
+			return nil, errs.NewStackError(
+				fmt.Errorf("%w| partition column %s has a null at row %d", arrowops.ErrNullValuesNotAllowed, partition.Name(), i),
+			)
+		}
+		key, err := PartitionKey(arr, i, partition)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// PartitionKey returns the partition key of the value at index idx of arr.
+func PartitionKey(arr arrow.Array, idx int, partition *elements.ColumnPartition) (string, error) {
+	switch options := partition.Options().(type) {
+	case *partitionFuncs.IntegerRangePartitionOptions:
+		value, err := integerValue(arr, idx)
+		if err != nil {
+			return "", err
+		}
+		return IntegerRangePartitionKey(value, options.Width), nil
+	case *partitionFuncs.StringHashPartitionOptions:
+		strArr, ok := arr.(*array.String)
+		if !ok {
+			return "", errs.NewStackError(fmt.Errorf("%w| string hash partition on %s", arrowops.ErrUnsupportedDataType, arr.DataType()))
+		}
+		return StringHashPartitionKey(strArr.Value(idx), options.PartitionCount), nil
+	default:
+		return "", errs.NewStackError(fmt.Errorf("%w| %T", ErrUnsupportedPartition, options))
+	}
+}
+
+func IntegerRangePartitionKey(value int64, width int) string {
+	w := int64(width)
+	key := value / w
+	// round towards negative infinity so that -1 is not in partition 0
+	if value%w != 0 && value < 0 {
+		key--
+	}
+	return strconv.FormatInt(key, 10)
+}
+
+func StringHashPartitionKey(value string, partitionCount int) string {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return strconv.FormatUint(uint64(h.Sum32()%uint32(partitionCount)), 10)
+}
+
+func integerValue(arr arrow.Array, idx int) (int64, error) {
+	switch a := arr.(type) {
+	case *array.Int8:
+		return int64(a.Value(idx)), nil
+	case *array.Int16:
+		return int64(a.Value(idx)), nil
+	case *array.Int32:
+		return int64(a.Value(idx)), nil
+	case *array.Int64:
+		return a.Value(idx), nil
+	case *array.Uint8:
+		return int64(a.Value(idx)), nil
+	case *array.Uint16:
+		return int64(a.Value(idx)), nil
+	case *array.Uint32:
+		return int64(a.Value(idx)), nil
+	case *array.Uint64:
+		value := a.Value(idx)
+		if value > math.MaxInt64 {
+			return 0, errs.NewStackError(fmt.Errorf("%w| %d does not fit an int64", ErrPartitionValueOutOfRange, value))
+		}
+		return int64(value), nil
+	default:
+		return 0, errs.NewStackError(fmt.Errorf("%w| integer range partition on %s", arrowops.ErrUnsupportedDataType, arr.DataType()))
+	}
+}
@@ -0,0 +1,568 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	arrowops "github.com/alekLukanen/arrow-ops"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/compute"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+/*
+A single transformation over a record. Apply must not take ownership
+of the input record; the returned record is owned by the caller and
+must be released by it.
+*/
+type PipelineStep interface {
+	Name() string
+	Apply(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error)
+}
+
+/*
+Pipeline chains steps over a record. Intermediate records are
+released as soon as the next step has produced its output, so only
+the final record is left for the caller to release. The steps select
+rows with the arrow compute take kernel, so every column type and its
+nulls pass through them.
+*/
+type Pipeline struct {
+	name  string
+	steps []PipelineStep
+}
+
+func NewPipeline(name string) *Pipeline {
+	return &Pipeline{name: name}
+}
+
+func (obj *Pipeline) AddSteps(steps ...PipelineStep) *Pipeline {
+	obj.steps = append(obj.steps, steps...)
+	return obj
+}
+
+func (obj *Pipeline) Name() string {
+	return obj.name
+}
+
+func (obj *Pipeline) Steps() []PipelineStep {
+	return obj.steps
+}
+
+func (obj *Pipeline) Run(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error) {
+	// claim the record
+	record.Retain()
+	current := record
+
+	for i, step := range obj.steps {
+		if ctx.Err() != nil {
+			current.Release()
+			return nil, errs.NewStackError(ctx.Err())
+		}
+
+		rowsIn := current.NumRows()
+		next, err := step.Apply(ctx, mem, current)
+		current.Release()
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("pipeline %s failed on step %d (%s)", obj.name, i, step.Name()))
+		}
+		current = next
+
+		switch step.(type) {
+		case *deduplicateStep, *deduplicateByOrderStep:
+			dedupRowsDropped.WithLabelValues(obj.name).Add(float64(rowsIn - next.NumRows()))
+		}
+	}
+
+	return current, nil
+}
+
+// Transformer adapts the pipeline to the signature used by
+// elements.NewExternalSubscription.
+func (obj *Pipeline) Transformer() Transformer {
+	return func(
+		ctx context.Context,
+		mem *memory.GoAllocator,
+		logger *slog.Logger,
+		record arrow.Record,
+	) (arrow.Record, error) {
+		logger.Info(fmt.Sprintf("transforming %s data", obj.name))
+		defer func() {
+			logger.Info(fmt.Sprintf("finished transformating %s data", obj.name))
+		}()
+
+		return obj.Run(ctx, mem, record)
+	}
+}
+
+type projectColumnsStep struct {
+	columns []string
+}
+
+// ProjectColumns keeps only the given columns in the given order.
+func ProjectColumns(columns ...string) PipelineStep {
+	return &projectColumnsStep{columns: columns}
+}
+
+func (obj *projectColumnsStep) Name() string {
+	return fmt.Sprintf("project%v", obj.columns)
+}
+
+func (obj *projectColumnsStep) Apply(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error) {
+	takenRec, err := arrowops.TakeRecordColumns(record, obj.columns)
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("failed taking columns: %v", obj.columns))
+	}
+	return takenRec, nil
+}
+
+type renameColumnsStep struct {
+	names map[string]string
+}
+
+// RenameColumns renames columns from the map keys to the map values.
+func RenameColumns(names map[string]string) PipelineStep {
+	return &renameColumnsStep{names: names}
+}
+
+func (obj *renameColumnsStep) Name() string {
+	return fmt.Sprintf("rename%v", obj.names)
+}
+
+func (obj *renameColumnsStep) Apply(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error) {
+	for oldName := range obj.names {
+		if len(record.Schema().FieldIndices(oldName)) == 0 {
+			return nil, errs.NewStackError(fmt.Errorf("%w| column name: %s", arrowops.ErrColumnNotFound, oldName))
+		}
+	}
+
+	fields := make([]arrow.Field, 0, record.NumCols())
+	for _, field := range record.Schema().Fields() {
+		if newName, ok := obj.names[field.Name]; ok {
+			field.Name = newName
+		}
+		fields = append(fields, field)
+	}
+
+	metadata := record.Schema().Metadata()
+	schema := arrow.NewSchema(fields, &metadata)
+	return array.NewRecord(schema, record.Columns(), record.NumRows()), nil
+}
+
+type castColumnStep struct {
+	column string
+	dtype  arrow.DataType
+}
+
+// CastColumn casts a column to dtype. Casts which would lose
+// data, such as overflowing integers, return an error.
+func CastColumn(column string, dtype arrow.DataType) PipelineStep {
+	return &castColumnStep{column: column, dtype: dtype}
+}
+
+func (obj *castColumnStep) Name() string {
+	return fmt.Sprintf("cast[%s:%s]", obj.column, obj.dtype)
+}
+
+func (obj *castColumnStep) Apply(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error) {
+	colIdx := record.Schema().FieldIndices(obj.column)
+	if len(colIdx) == 0 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| column name: %s", arrowops.ErrColumnNotFound, obj.column))
+	}
+
+	castCtx := compute.WithAllocator(ctx, mem)
+	castArr, err := compute.CastArray(castCtx, record.Column(colIdx[0]), compute.SafeCastOptions(obj.dtype))
+	if err != nil {
+		return nil, errs.Wrap(errs.NewStackError(err), fmt.Errorf("failed casting column %s to %s", obj.column, obj.dtype))
+	}
+	defer castArr.Release()
+
+	fields := record.Schema().Fields()
+	fields[colIdx[0]].Type = obj.dtype
+	columns := append([]arrow.Array{}, record.Columns()...)
+	columns[colIdx[0]] = castArr
+
+	metadata := record.Schema().Metadata()
+	schema := arrow.NewSchema(fields, &metadata)
+	return array.NewRecord(schema, columns, record.NumRows()), nil
+}
+
+// RowPredicate reports whether the row at index row should be kept.
+type RowPredicate func(record arrow.Record, row int) (bool, error)
+
+type filterStep struct {
+	name      string
+	predicate RowPredicate
+}
+
+// Filter keeps the rows for which the predicate returns true.
+func Filter(name string, predicate RowPredicate) PipelineStep {
+	return &filterStep{name: name, predicate: predicate}
+}
+
+func (obj *filterStep) Name() string {
+	return fmt.Sprintf("filter[%s]", obj.name)
+}
+
+func (obj *filterStep) Apply(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error) {
+	rowIndices := make([]uint32, 0, record.NumRows())
+	for i := 0; i < int(record.NumRows()); i++ {
+		keep, err := obj.predicate(record, i)
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("predicate failed on row %d", i))
+		}
+		if keep {
+			rowIndices = append(rowIndices, uint32(i))
+		}
+	}
+	return takeRows(ctx, mem, record, rowIndices)
+}
+
+type deduplicateStep struct {
+	keys []string
+}
+
+/*
+Deduplicate keeps the first row of each unique combination of the key
+columns. Rows are grouped by the string values of their keys, so keys
+may be of any type, and the kept rows stay in their input order.
+*/
+func Deduplicate(keys ...string) PipelineStep {
+	return &deduplicateStep{keys: keys}
+}
+
+func (obj *deduplicateStep) Name() string {
+	return fmt.Sprintf("deduplicate%v", obj.keys)
+}
+
+func (obj *deduplicateStep) Apply(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error) {
+	if len(obj.keys) == 0 {
+		return nil, errs.NewStackError(arrowops.ErrColumnNamesRequired)
+	}
+	rowKeys, err := groupKeys(record, obj.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(rowKeys))
+	rowIndices := make([]uint32, 0, len(rowKeys))
+	for i, key := range rowKeys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		rowIndices = append(rowIndices, uint32(i))
+	}
+	if len(rowIndices) == len(rowKeys) {
+		record.Retain()
+		return record, nil
+	}
+	return takeRows(ctx, mem, record, rowIndices)
+}
+
+type deduplicateByOrderStep struct {
+	keys        []string
+	orderColumn string
+	keepMax     bool
+}
+
+/*
+DeduplicateLatest keeps, for each unique combination of the key
+columns, the row with the largest value in orderColumn. This gives
+last-write-wins semantics when orderColumn is a sequence number. Rows
+are grouped by the string values of their keys, so keys may be of any
+type; a null order value is smaller than every other value, and of
+rows with equal order values the first is kept. The kept rows stay in
+their input order.
+
+The step only sees the record it is applied to. A transformer runs on
+one batch of inserted records at a time, so a newer version of a key
+in an earlier batch does not stop an older version in a later batch
+from being written over it; last-write-wins holds within a batch only.
+*/
+func DeduplicateLatest(orderColumn string, keys ...string) PipelineStep {
+	return &deduplicateByOrderStep{keys: keys, orderColumn: orderColumn, keepMax: true}
+}
+
+// DeduplicateEarliest is like DeduplicateLatest but keeps the row
+// with the smallest value in orderColumn.
+func DeduplicateEarliest(orderColumn string, keys ...string) PipelineStep {
+	return &deduplicateByOrderStep{keys: keys, orderColumn: orderColumn, keepMax: false}
+}
+
+func (obj *deduplicateByOrderStep) Name() string {
+	if obj.keepMax {
+		return fmt.Sprintf("deduplicateLatest%v[%s]", obj.keys, obj.orderColumn)
+	}
+	return fmt.Sprintf("deduplicateEarliest%v[%s]", obj.keys, obj.orderColumn)
+}
+
+func (obj *deduplicateByOrderStep) Apply(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error) {
+	if len(obj.keys) == 0 {
+		return nil, errs.NewStackError(arrowops.ErrColumnNamesRequired)
+	}
+	orderIdx := record.Schema().FieldIndices(obj.orderColumn)
+	if len(orderIdx) == 0 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| column name: %s", arrowops.ErrColumnNotFound, obj.orderColumn))
+	}
+	compareOrder, err := orderComparator(record.Column(orderIdx[0]))
+	if err != nil {
+		return nil, err
+	}
+	rowKeys, err := groupKeys(record, obj.keys)
+	if err != nil {
+		return nil, err
+	}
+	if record.NumRows() == 0 {
+		record.Retain()
+		return record, nil
+	}
+
+	// the kept row of each key group
+	kept := make(map[string]int, len(rowKeys))
+	for i, key := range rowKeys {
+		keptRow, ok := kept[key]
+		if !ok {
+			kept[key] = i
+			continue
+		}
+		cmpOrder := compareOrder(i, keptRow)
+		if (obj.keepMax && cmpOrder > 0) || (!obj.keepMax && cmpOrder < 0) {
+			kept[key] = i
+		}
+	}
+
+	rowIndices := make([]uint32, 0, len(kept))
+	for _, row := range kept {
+		rowIndices = append(rowIndices, uint32(row))
+	}
+	slices.Sort(rowIndices)
+	return takeRows(ctx, mem, record, rowIndices)
+}
+
+/*
+groupKeys returns the group key of each row of the record, built from
+the string values of the columns. Each value is prefixed with its
+length, and nulls with -1, so that distinct combinations never share
+a key.
+*/
+func groupKeys(record arrow.Record, columns []string) ([]string, error) {
+	arrs := make([]arrow.Array, len(columns))
+	for i, colName := range columns {
+		colIdx := record.Schema().FieldIndices(colName)
+		if len(colIdx) == 0 {
+			return nil, errs.NewStackError(fmt.Errorf("%w| column name: %s", arrowops.ErrColumnNotFound, colName))
+		}
+		arrs[i] = record.Column(colIdx[0])
+	}
+
+	keys := make([]string, record.NumRows())
+	var sb strings.Builder
+	for row := range keys {
+		sb.Reset()
+		for _, arr := range arrs {
+			if arr.IsNull(row) {
+				sb.WriteString("-1:")
+				continue
+			}
+			value := arr.ValueStr(row)
+			sb.WriteString(strconv.Itoa(len(value)))
+			sb.WriteByte(':')
+			sb.WriteString(value)
+		}
+		keys[row] = sb.String()
+	}
+	return keys, nil
+}
+
+// orderComparator returns a function comparing two rows of the array
+// by value, with nulls before every other value.
+func orderComparator(arr arrow.Array) (func(i, j int) int, error) {
+	switch a := arr.(type) {
+	case *array.Int8:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Int16:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Int32:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Int64:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Uint8:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Uint16:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Uint32:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Uint64:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Float32:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Float64:
+		return compareOrdered(arr, a.Value), nil
+	case *array.String:
+		return compareOrdered(arr, a.Value), nil
+	case *array.LargeString:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Date32:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Date64:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Timestamp:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Time32:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Time64:
+		return compareOrdered(arr, a.Value), nil
+	case *array.Duration:
+		return compareOrdered(arr, a.Value), nil
+	default:
+		return nil, errs.NewStackError(fmt.Errorf("%w| order column type: %s", arrowops.ErrUnsupportedDataType, arr.DataType()))
+	}
+}
+
+func compareOrdered[T cmp.Ordered](arr arrow.Array, value func(int) T) func(i, j int) int {
+	return func(i, j int) int {
+		switch {
+		case arr.IsNull(i) && arr.IsNull(j):
+			return 0
+		case arr.IsNull(i):
+			return -1
+		case arr.IsNull(j):
+			return 1
+		}
+		return cmp.Compare(value(i), value(j))
+	}
+}
+
+// ColumnFunc builds an array with one value per row of the record.
+type ColumnFunc func(mem *memory.GoAllocator, record arrow.Record) (arrow.Array, error)
+
+type addColumnStep struct {
+	field   arrow.Field
+	compute ColumnFunc
+}
+
+// AddColumn appends a column computed from the other columns.
+func AddColumn(field arrow.Field, compute ColumnFunc) PipelineStep {
+	return &addColumnStep{field: field, compute: compute}
+}
+
+func (obj *addColumnStep) Name() string {
+	return fmt.Sprintf("addColumn[%s]", obj.field.Name)
+}
+
+func (obj *addColumnStep) Apply(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error) {
+	if len(record.Schema().FieldIndices(obj.field.Name)) > 0 {
+		return nil, errs.NewStackError(fmt.Errorf("%w| column name: %s", ErrColumnExists, obj.field.Name))
+	}
+
+	arr, err := obj.compute(mem, record)
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("failed computing column %s", obj.field.Name))
+	}
+	defer arr.Release()
+
+	if int64(arr.Len()) != record.NumRows() {
+		return nil, errs.NewStackError(
+			fmt.Errorf("%w| column %s has %d rows, record has %d", arrowops.ErrRecordNotComplete, obj.field.Name, arr.Len(), record.NumRows()),
+		)
+	}
+	if !arrow.TypeEqual(arr.DataType(), obj.field.Type) {
+		return nil, errs.NewStackError(
+			fmt.Errorf("%w| column %s is %s, expected %s", arrowops.ErrDataTypesNotEqual, obj.field.Name, arr.DataType(), obj.field.Type),
+		)
+	}
+
+	fields := append(record.Schema().Fields(), obj.field)
+	columns := append(append([]arrow.Array{}, record.Columns()...), arr)
+
+	metadata := record.Schema().Metadata()
+	schema := arrow.NewSchema(fields, &metadata)
+	return array.NewRecord(schema, columns, record.NumRows()), nil
+}
+
+type dropNullsStep struct {
+	columns []string
+}
+
+// DropNulls removes rows with a null in any of the columns,
+// or in any column at all when no columns are given.
+func DropNulls(columns ...string) PipelineStep {
+	return &dropNullsStep{columns: columns}
+}
+
+func (obj *dropNullsStep) Name() string {
+	return fmt.Sprintf("dropNulls%v", obj.columns)
+}
+
+func (obj *dropNullsStep) Apply(ctx context.Context, mem *memory.GoAllocator, record arrow.Record) (arrow.Record, error) {
+	arrs := make([]arrow.Array, 0, record.NumCols())
+	if len(obj.columns) == 0 {
+		arrs = append(arrs, record.Columns()...)
+	} else {
+		for _, colName := range obj.columns {
+			colIdx := record.Schema().FieldIndices(colName)
+			if len(colIdx) == 0 {
+				return nil, errs.NewStackError(fmt.Errorf("%w| column name: %s", arrowops.ErrColumnNotFound, colName))
+			}
+			arrs = append(arrs, record.Column(colIdx[0]))
+		}
+	}
+
+	// nothing to drop
+	hasNulls := false
+	for _, arr := range arrs {
+		if arr.NullN() > 0 {
+			hasNulls = true
+			break
+		}
+	}
+	if !hasNulls {
+		record.Retain()
+		return record, nil
+	}
+
+	rowIndices := make([]uint32, 0, record.NumRows())
+	for i := 0; i < int(record.NumRows()); i++ {
+		keep := true
+		for _, arr := range arrs {
+			if arr.IsNull(i) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			rowIndices = append(rowIndices, uint32(i))
+		}
+	}
+	return takeRows(ctx, mem, record, rowIndices)
+}
+
+// takeRows takes the rows with the arrow compute take kernel, which
+// keeps the validity of every column.
+func takeRows(ctx context.Context, mem memory.Allocator, record arrow.Record, rowIndices []uint32) (arrow.Record, error) {
+	indicesBuilder := array.NewUint32Builder(mem)
+	defer indicesBuilder.Release()
+	indicesBuilder.AppendValues(rowIndices, nil)
+
+	indices := indicesBuilder.NewUint32Array()
+	defer indices.Release()
+
+	taken, err := compute.Take(
+		compute.WithAllocator(ctx, mem),
+		*compute.DefaultTakeOptions(),
+		compute.NewDatumWithoutOwning(record),
+		compute.NewDatumWithoutOwning(indices),
+	)
+	if err != nil {
+		return nil, errs.Wrap(errs.NewStackError(err), fmt.Errorf("failed to take %d rows from record", indices.Len()))
+	}
+	return taken.(*compute.RecordDatum).Value, nil
+}
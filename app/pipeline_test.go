@@ -0,0 +1,389 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	arrowops "github.com/alekLukanen/arrow-ops"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// pipelineTestSchema has a column of every kind the steps must carry:
+// nullable keys, a sequence, a decimal and a list.
+var pipelineTestSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "id", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+	{Name: "k", Type: arrow.PrimitiveTypes.Int8, Nullable: true},
+	{Name: "seq", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+	{Name: "d", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}, Nullable: true},
+	{Name: "l", Type: arrow.ListOf(arrow.PrimitiveTypes.Int32), Nullable: true},
+}, nil)
+
+const pipelineTestRows = `[
+	{"id": 1, "k": 1, "seq": 1, "d": "1.25", "l": [1, 2]},
+	{"id": 1, "k": 1, "seq": 3, "d": null, "l": null},
+	{"id": 2, "k": 1, "seq": 2, "d": "-3.50", "l": []},
+	{"id": 1, "k": 2, "seq": null, "d": "0.01", "l": [3]},
+	{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]},
+	{"id": 1, "k": 1, "seq": 3, "d": "7.00", "l": [4]}
+]`
+
+func testRecord(t *testing.T, schema *arrow.Schema, rows string) arrow.Record {
+	t.Helper()
+	record, _, err := array.RecordFromJSON(memory.NewGoAllocator(), schema, strings.NewReader(rows))
+	if err != nil {
+		t.Fatalf("RecordFromJSON: %v", err)
+	}
+	return record
+}
+
+func TestPipelineSteps(t *testing.T) {
+	oddSeq := func(record arrow.Record, row int) (bool, error) {
+		seq := record.Column(2).(*array.Int64)
+		return seq.IsValid(row) && seq.Value(row)%2 == 1, nil
+	}
+	idPlusOne := func(mem *memory.GoAllocator, record arrow.Record) (arrow.Array, error) {
+		ids := record.Column(0).(*array.Int32)
+		builder := array.NewInt64Builder(mem)
+		defer builder.Release()
+		for i := 0; i < ids.Len(); i++ {
+			if ids.IsNull(i) {
+				builder.AppendNull()
+			} else {
+				builder.Append(int64(ids.Value(i)) + 1)
+			}
+		}
+		return builder.NewArray(), nil
+	}
+
+	tests := []struct {
+		name   string
+		step   PipelineStep
+		schema *arrow.Schema
+		want   string
+	}{
+		{
+			name:   "project",
+			step:   ProjectColumns("l", "id"),
+			schema: arrow.NewSchema([]arrow.Field{pipelineTestSchema.Field(4), pipelineTestSchema.Field(0)}, nil),
+			want:   `[{"l": [1, 2], "id": 1}, {"l": null, "id": 1}, {"l": [], "id": 2}, {"l": [3], "id": 1}, {"l": [null], "id": null}, {"l": [4], "id": 1}]`,
+		},
+		{
+			name: "rename",
+			step: RenameColumns(map[string]string{"d": "amount"}),
+			schema: arrow.NewSchema([]arrow.Field{
+				pipelineTestSchema.Field(0), pipelineTestSchema.Field(1), pipelineTestSchema.Field(2),
+				{Name: "amount", Type: pipelineTestSchema.Field(3).Type, Nullable: true}, pipelineTestSchema.Field(4),
+			}, nil),
+			want: strings.ReplaceAll(pipelineTestRows, `"d"`, `"amount"`),
+		},
+		{
+			name: "cast keeps nulls",
+			step: CastColumn("id", arrow.PrimitiveTypes.Int64),
+			schema: arrow.NewSchema([]arrow.Field{
+				{Name: "id", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+				pipelineTestSchema.Field(1), pipelineTestSchema.Field(2), pipelineTestSchema.Field(3), pipelineTestSchema.Field(4),
+			}, nil),
+			want: pipelineTestRows,
+		},
+		{
+			name: "filter keeps nulls, decimals and lists",
+			step: Filter("odd seq", oddSeq),
+			want: `[
+				{"id": 1, "k": 1, "seq": 1, "d": "1.25", "l": [1, 2]},
+				{"id": 1, "k": 1, "seq": 3, "d": null, "l": null},
+				{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]},
+				{"id": 1, "k": 1, "seq": 3, "d": "7.00", "l": [4]}
+			]`,
+		},
+		{
+			name: "deduplicate keeps the first row and groups nulls",
+			step: Deduplicate("id", "k"),
+			want: `[
+				{"id": 1, "k": 1, "seq": 1, "d": "1.25", "l": [1, 2]},
+				{"id": 2, "k": 1, "seq": 2, "d": "-3.50", "l": []},
+				{"id": 1, "k": 2, "seq": null, "d": "0.01", "l": [3]},
+				{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]}
+			]`,
+		},
+		{
+			name: "deduplicate on a decimal key",
+			step: Deduplicate("d"),
+			want: `[
+				{"id": 1, "k": 1, "seq": 1, "d": "1.25", "l": [1, 2]},
+				{"id": 1, "k": 1, "seq": 3, "d": null, "l": null},
+				{"id": 2, "k": 1, "seq": 2, "d": "-3.50", "l": []},
+				{"id": 1, "k": 2, "seq": null, "d": "0.01", "l": [3]},
+				{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]},
+				{"id": 1, "k": 1, "seq": 3, "d": "7.00", "l": [4]}
+			]`,
+		},
+		{
+			name: "latest keeps the first of equal orders",
+			step: DeduplicateLatest("seq", "id", "k"),
+			want: `[
+				{"id": 1, "k": 1, "seq": 3, "d": null, "l": null},
+				{"id": 2, "k": 1, "seq": 2, "d": "-3.50", "l": []},
+				{"id": 1, "k": 2, "seq": null, "d": "0.01", "l": [3]},
+				{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]}
+			]`,
+		},
+		{
+			name: "latest on three key columns with an int8 key",
+			step: DeduplicateLatest("seq", "k", "id", "seq"),
+			want: `[
+				{"id": 1, "k": 1, "seq": 1, "d": "1.25", "l": [1, 2]},
+				{"id": 1, "k": 1, "seq": 3, "d": null, "l": null},
+				{"id": 2, "k": 1, "seq": 2, "d": "-3.50", "l": []},
+				{"id": 1, "k": 2, "seq": null, "d": "0.01", "l": [3]},
+				{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]}
+			]`,
+		},
+		{
+			name: "latest on the int8 key alone",
+			step: DeduplicateLatest("seq", "k"),
+			want: `[
+				{"id": 1, "k": 2, "seq": null, "d": "0.01", "l": [3]},
+				{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]}
+			]`,
+		},
+		{
+			name: "earliest orders nulls first",
+			step: DeduplicateEarliest("seq", "id"),
+			want: `[
+				{"id": 2, "k": 1, "seq": 2, "d": "-3.50", "l": []},
+				{"id": 1, "k": 2, "seq": null, "d": "0.01", "l": [3]},
+				{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]}
+			]`,
+		},
+		{
+			name: "add column",
+			step: AddColumn(arrow.Field{Name: "next", Type: arrow.PrimitiveTypes.Int64, Nullable: true}, idPlusOne),
+			schema: arrow.NewSchema(append(pipelineTestSchema.Fields(),
+				arrow.Field{Name: "next", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+			), nil),
+			want: `[
+				{"id": 1, "k": 1, "seq": 1, "d": "1.25", "l": [1, 2], "next": 2},
+				{"id": 1, "k": 1, "seq": 3, "d": null, "l": null, "next": 2},
+				{"id": 2, "k": 1, "seq": 2, "d": "-3.50", "l": [], "next": 3},
+				{"id": 1, "k": 2, "seq": null, "d": "0.01", "l": [3], "next": 2},
+				{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null], "next": null},
+				{"id": 1, "k": 1, "seq": 3, "d": "7.00", "l": [4], "next": 2}
+			]`,
+		},
+		{
+			name: "drop nulls in a column",
+			step: DropNulls("d"),
+			want: `[
+				{"id": 1, "k": 1, "seq": 1, "d": "1.25", "l": [1, 2]},
+				{"id": 2, "k": 1, "seq": 2, "d": "-3.50", "l": []},
+				{"id": 1, "k": 2, "seq": null, "d": "0.01", "l": [3]},
+				{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]},
+				{"id": 1, "k": 1, "seq": 3, "d": "7.00", "l": [4]}
+			]`,
+		},
+		{
+			name: "drop nulls in any column",
+			step: DropNulls(),
+			want: `[
+				{"id": 1, "k": 1, "seq": 1, "d": "1.25", "l": [1, 2]},
+				{"id": 2, "k": 1, "seq": 2, "d": "-3.50", "l": []},
+				{"id": 1, "k": 1, "seq": 3, "d": "7.00", "l": [4]}
+			]`,
+		},
+		{
+			name: "drop nulls of a column without nulls",
+			step: DropNulls("k"),
+			want: pipelineTestRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := testRecord(t, pipelineTestSchema, pipelineTestRows)
+			defer input.Release()
+			schema := tt.schema
+			if schema == nil {
+				schema = pipelineTestSchema
+			}
+			want := testRecord(t, schema, tt.want)
+			defer want.Release()
+
+			got, err := tt.step.Apply(context.Background(), memory.NewGoAllocator(), input)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.step.Name(), err)
+			}
+			defer got.Release()
+			if !array.RecordEqual(got, want) {
+				t.Fatalf("%s returned\n%v\nwant\n%v", tt.step.Name(), got, want)
+			}
+			// the step must not have taken the input
+			if input.NumRows() != 6 || input.Column(0).Len() != 6 {
+				t.Fatalf("the input record changed")
+			}
+		})
+	}
+}
+
+func TestPipelineStepErrors(t *testing.T) {
+	wrongLength := func(mem *memory.GoAllocator, record arrow.Record) (arrow.Array, error) {
+		builder := array.NewInt64Builder(mem)
+		defer builder.Release()
+		builder.Append(1)
+		return builder.NewArray(), nil
+	}
+	wrongType := func(mem *memory.GoAllocator, record arrow.Record) (arrow.Array, error) {
+		builder := array.NewInt32Builder(mem)
+		defer builder.Release()
+		builder.AppendValues(make([]int32, record.NumRows()), nil)
+		return builder.NewArray(), nil
+	}
+	failing := func(arrow.Record, int) (bool, error) { return false, arrowops.ErrIndexOutOfBounds }
+
+	tests := []struct {
+		name string
+		step PipelineStep
+		err  error
+	}{
+		{"project a missing column", ProjectColumns("missing"), arrowops.ErrColumnNotFound},
+		{"rename a missing column", RenameColumns(map[string]string{"missing": "x"}), arrowops.ErrColumnNotFound},
+		{"cast a missing column", CastColumn("missing", arrow.PrimitiveTypes.Int64), arrowops.ErrColumnNotFound},
+		{"filter predicate error", Filter("failing", failing), arrowops.ErrIndexOutOfBounds},
+		{"deduplicate without keys", Deduplicate(), arrowops.ErrColumnNamesRequired},
+		{"deduplicate a missing key", Deduplicate("missing"), arrowops.ErrColumnNotFound},
+		{"latest without keys", DeduplicateLatest("seq"), arrowops.ErrColumnNamesRequired},
+		{"latest by a missing column", DeduplicateLatest("missing", "id"), arrowops.ErrColumnNotFound},
+		{"latest by a list", DeduplicateLatest("l", "id"), arrowops.ErrUnsupportedDataType},
+		{"add an existing column", AddColumn(arrow.Field{Name: "id", Type: arrow.PrimitiveTypes.Int64}, wrongLength), ErrColumnExists},
+		{"add a column of the wrong length", AddColumn(arrow.Field{Name: "x", Type: arrow.PrimitiveTypes.Int64}, wrongLength), arrowops.ErrRecordNotComplete},
+		{"add a column of the wrong type", AddColumn(arrow.Field{Name: "x", Type: arrow.PrimitiveTypes.Int64}, wrongType), arrowops.ErrDataTypesNotEqual},
+		{"drop nulls of a missing column", DropNulls("missing"), arrowops.ErrColumnNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := testRecord(t, pipelineTestSchema, pipelineTestRows)
+			defer input.Release()
+
+			got, err := tt.step.Apply(context.Background(), memory.NewGoAllocator(), input)
+			if err == nil {
+				got.Release()
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("%s: got %v, want %v", tt.step.Name(), err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCastColumnOverflow(t *testing.T) {
+	input := testRecord(t, pipelineTestSchema, `[{"id": 1, "k": 1, "seq": 1000, "d": null, "l": null}]`)
+	defer input.Release()
+
+	got, err := CastColumn("seq", arrow.PrimitiveTypes.Int8).Apply(context.Background(), memory.NewGoAllocator(), input)
+	if err == nil {
+		got.Release()
+		t.Fatalf("casting 1000 to int8 did not fail")
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	input := testRecord(t, pipelineTestSchema, pipelineTestRows)
+	defer input.Release()
+
+	pipeline := NewPipeline("test").AddSteps(
+		DropNulls("id"),
+		DeduplicateLatest("seq", "id"),
+		ProjectColumns("id", "d"),
+	)
+	got, err := pipeline.Run(context.Background(), memory.NewGoAllocator(), input)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	defer got.Release()
+
+	want := testRecord(t,
+		arrow.NewSchema([]arrow.Field{pipelineTestSchema.Field(0), pipelineTestSchema.Field(3)}, nil),
+		`[{"id": 1, "d": null}, {"id": 2, "d": "-3.50"}]`,
+	)
+	defer want.Release()
+	if !array.RecordEqual(got, want) {
+		t.Fatalf("Run returned\n%v\nwant\n%v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pipeline.Run(ctx, memory.NewGoAllocator(), input)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run with a cancelled context: got %v, want context.Canceled", err)
+	}
+}
+
+func TestTakeRowsReleasesMemory(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	input, _, err := array.RecordFromJSON(mem, pipelineTestSchema, strings.NewReader(pipelineTestRows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken, err := takeRows(context.Background(), mem, input, []uint32{4, 1})
+	input.Release()
+	if err != nil {
+		t.Fatalf("takeRows: %v", err)
+	}
+
+	want := testRecord(t, pipelineTestSchema, `[
+		{"id": null, "k": 1, "seq": 5, "d": "9.99", "l": [null]},
+		{"id": 1, "k": 1, "seq": 3, "d": null, "l": null}
+	]`)
+	defer want.Release()
+	if !array.RecordEqual(taken, want) {
+		t.Fatalf("takeRows returned\n%v\nwant\n%v", taken, want)
+	}
+	taken.Release()
+}
+
+func TestTable1TransformerDeduplicatesPerBatch(t *testing.T) {
+	fields := make([]arrow.Field, 0)
+	for _, col := range Table1SourceColumns() {
+		fields = append(fields, arrow.Field{Name: col.Name, Type: col.Dtype, Nullable: true})
+	}
+	schema := arrow.NewSchema(fields, nil)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	run := func(rows string) arrow.Record {
+		t.Helper()
+		input := testRecord(t, schema, rows)
+		defer input.Release()
+		output, err := Table1Transformer(context.Background(), memory.NewGoAllocator(), logger, input)
+		if err != nil {
+			t.Fatalf("Table1Transformer: %v", err)
+		}
+		return output
+	}
+
+	// within a batch the newest sampleId wins
+	first := run(`[
+		{"column1": 1, "column2": false, "column3": 1, "eventName": "a", "sampleId": 5},
+		{"column1": 1, "column2": true, "column3": 2, "eventName": "b", "sampleId": 7},
+		{"column1": 2, "column2": true, "column3": 3, "eventName": "c", "sampleId": 6}
+	]`)
+	defer first.Release()
+	if first.NumRows() != 2 || first.Column(2).(*array.Float64).Value(0) != 2 {
+		t.Fatalf("first batch returned\n%v", first)
+	}
+
+	// an older version in a later batch is still written, since the
+	// step only sees its own batch
+	second := run(`[{"column1": 1, "column2": false, "column3": 9, "eventName": "d", "sampleId": 3}]`)
+	defer second.Release()
+	if second.NumRows() != 1 || second.Column(2).(*array.Float64).Value(0) != 9 {
+		t.Fatalf("second batch returned\n%v", second)
+	}
+}
@@ -2,19 +2,32 @@ package app
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
 	"github.com/alekLukanen/ChapterhouseDB-v1/partitionFuncs"
-	arrowops "github.com/alekLukanen/arrow-ops"
-	"github.com/alekLukanen/errs"
 	"github.com/apache/arrow/go/v17/arrow"
 	"github.com/apache/arrow/go/v17/arrow/memory"
 )
 
-func BuildTable1() *elements.Table {
+/*
+Builds table1. Its subscription runs the transformer registered as
+"table1", so transformers must come from BuildTransformerRegistry.
+*/
+func BuildTable1(transformers *TransformerRegistry) (*elements.Table, error) {
+	partitions := []*elements.ColumnPartition{
+		elements.NewColumnPartition(
+			"column1",
+			partitionFuncs.NewIntegerRangePartitionOptions(1000),
+		),
+	}
+
+	transformer, err := transformers.Subscribe("table1", "sourceSystemTable1", "table1", partitions)
+	if err != nil {
+		return nil, err
+	}
+
 	table1 := elements.NewTable("table1").
 		AddColumns(
 			elements.NewColumn("column1", arrow.PrimitiveTypes.Int32),
@@ -28,12 +41,7 @@ func BuildTable1() *elements.Table {
 				MaxObjectSize:        10_000,
 			},
 		).
-		AddColumnPartitions(
-			elements.NewColumnPartition(
-				"column1",
-				partitionFuncs.NewIntegerRangePartitionOptions(1000),
-			),
-		).
+		AddColumnPartitions(partitions...).
 		AddSubscriptionGroups(
 			elements.NewSubscriptionGroup(
 				"group1",
@@ -41,51 +49,52 @@ func BuildTable1() *elements.Table {
 				AddSubscriptions(
 					elements.NewExternalSubscription(
 						"sourceSystemTable1",
-						Table1Transformer,
-						[]elements.Column{
-							elements.NewColumn("column1", &arrow.Int32Type{}),
-							elements.NewColumn("column2", &arrow.BooleanType{}),
-							elements.NewColumn("column3", &arrow.Float64Type{}),
-							elements.NewColumn("eventName", &arrow.StringType{}),
-							elements.NewColumn("sampleId", &arrow.Int32Type{}),
-						},
+						transformer,
+						Table1SourceColumns(),
 					),
 				),
 		)
 
-	return table1
+	return table1, nil
 
 }
 
+// Table1SourceColumns are the columns of the rows sourceSystemTable1
+// inserts into table1.
+func Table1SourceColumns() []elements.Column {
+	return []elements.Column{
+		elements.NewColumn("column1", &arrow.Int32Type{}),
+		elements.NewColumn("column2", &arrow.BooleanType{}),
+		elements.NewColumn("column3", &arrow.Float64Type{}),
+		elements.NewColumn("eventName", &arrow.StringType{}),
+		elements.NewColumn("sampleId", &arrow.Int32Type{}),
+	}
+}
+
+// Table1ValidationSpec keys table1 by column1 and expects the
+// newest sampleId of each key, which holds while every version of a
+// key is sent in the same record.
+func Table1ValidationSpec() ValidationSpec {
+	return ValidationSpec{
+		KeyColumns:  []string{"column1"},
+		OrderColumn: "sampleId",
+	}
+}
+
+// the newest sample of each column1 value in a batch wins; sampleId is
+// not written to the table, so batches are not ordered against each
+// other and each key must only be sent in one record
+var table1Pipeline = NewPipeline("table1").
+	AddSteps(
+		DeduplicateLatest("sampleId", "column1"),
+		ProjectColumns("column1", "column2", "column3"),
+	)
+
 func Table1Transformer(
 	ctx context.Context,
 	mem *memory.GoAllocator,
 	logger *slog.Logger,
 	record arrow.Record,
 ) (arrow.Record, error) {
-	logger.Info("transforming table1 data")
-	defer func() {
-		logger.Info("finished transformating table1 data")
-	}()
-
-	// claim the record
-	record.Retain()
-	defer record.Release()
-
-	// Transform data here
-	columns := []string{"column1", "column2", "column3"}
-	takenRec, err := arrowops.TakeRecordColumns(record, columns)
-	if err != nil {
-		return nil, errs.Wrap(err, fmt.Errorf("failed taking columns: %v", columns))
-	}
-	defer takenRec.Release()
-
-	// deduplicate the record
-	dedupColumns := []string{"column1"}
-	dedupRec, err := arrowops.DeduplicateRecord(mem, takenRec, dedupColumns, false)
-	if err != nil {
-		return nil, errs.Wrap(err, fmt.Errorf("deduplicating by columns: %v", dedupColumns))
-	}
-
-	return dedupRec, nil
+	return table1Pipeline.Transformer()(ctx, mem, logger, record)
 }
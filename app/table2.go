@@ -2,20 +2,33 @@ package app
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
 	"github.com/alekLukanen/ChapterhouseDB-v1/partitionFuncs"
-	arrowops "github.com/alekLukanen/arrow-ops"
-	"github.com/alekLukanen/errs"
 	"github.com/apache/arrow/go/v17/arrow"
 	"github.com/apache/arrow/go/v17/arrow/memory"
 )
 
-func BuildTable2() *elements.Table {
-	table1 := elements.NewTable("table2").
+/*
+Builds table2. Its subscription runs the transformer registered as
+"table2", so transformers must come from BuildTransformerRegistry.
+*/
+func BuildTable2(transformers *TransformerRegistry) (*elements.Table, error) {
+	partitions := []*elements.ColumnPartition{
+		elements.NewColumnPartition(
+			"column1",
+			partitionFuncs.NewStringHashPartitionOptions(10, partitionFuncs.MethodFNVHash),
+		),
+	}
+
+	transformer, err := transformers.Subscribe("table2", "sourceSystemTable2", "table2", partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	table2 := elements.NewTable("table2").
 		AddColumns(
 			elements.NewColumn("column1", arrow.BinaryTypes.String),
 			elements.NewColumn("column2", arrow.FixedWidthTypes.Boolean),
@@ -28,12 +41,7 @@ func BuildTable2() *elements.Table {
 				MaxObjectSize:        10_000,
 			},
 		).
-		AddColumnPartitions(
-			elements.NewColumnPartition(
-				"column1",
-				partitionFuncs.NewStringHashPartitionOptions(10, partitionFuncs.MethodFNVHash),
-			),
-		).
+		AddColumnPartitions(partitions...).
 		AddSubscriptionGroups(
 			elements.NewSubscriptionGroup(
 				"group1",
@@ -41,51 +49,52 @@ func BuildTable2() *elements.Table {
 				AddSubscriptions(
 					elements.NewExternalSubscription(
 						"sourceSystemTable2",
-						Table1Transformer,
-						[]elements.Column{
-							elements.NewColumn("column1", &arrow.StringType{}),
-							elements.NewColumn("column2", &arrow.BooleanType{}),
-							elements.NewColumn("column3", &arrow.Float64Type{}),
-							elements.NewColumn("eventName", &arrow.StringType{}),
-							elements.NewColumn("sampleId", &arrow.Int32Type{}),
-						},
+						transformer,
+						Table2SourceColumns(),
 					),
 				),
 		)
 
-	return table1
+	return table2, nil
 
 }
 
+// Table2SourceColumns are the columns of the rows sourceSystemTable2
+// inserts into table2.
+func Table2SourceColumns() []elements.Column {
+	return []elements.Column{
+		elements.NewColumn("column1", &arrow.StringType{}),
+		elements.NewColumn("column2", &arrow.BooleanType{}),
+		elements.NewColumn("column3", &arrow.Float64Type{}),
+		elements.NewColumn("eventName", &arrow.StringType{}),
+		elements.NewColumn("sampleId", &arrow.Int32Type{}),
+	}
+}
+
+// Table2ValidationSpec keys table2 by column1 and expects the
+// newest sampleId of each key, which holds while every version of a
+// key is sent in the same record.
+func Table2ValidationSpec() ValidationSpec {
+	return ValidationSpec{
+		KeyColumns:  []string{"column1"},
+		OrderColumn: "sampleId",
+	}
+}
+
+// the newest sample of each column1 value in a batch wins; sampleId is
+// not written to the table, so batches are not ordered against each
+// other and each key must only be sent in one record
+var table2Pipeline = NewPipeline("table2").
+	AddSteps(
+		DeduplicateLatest("sampleId", "column1"),
+		ProjectColumns("column1", "column2", "column3"),
+	)
+
 func Table2Transformer(
 	ctx context.Context,
 	mem *memory.GoAllocator,
 	logger *slog.Logger,
 	record arrow.Record,
 ) (arrow.Record, error) {
-	logger.Info("transforming table2 data")
-	defer func() {
-		logger.Info("finished transformating table2 data")
-	}()
-
-	// claim the record
-	record.Retain()
-	defer record.Release()
-
-	// Transform data here
-	columns := []string{"column1", "column2", "column3"}
-	takenRec, err := arrowops.TakeRecordColumns(record, columns)
-	if err != nil {
-		return nil, errs.Wrap(err, fmt.Errorf("failed taking columns: %v", columns))
-	}
-	defer takenRec.Release()
-
-	// deduplicate the record
-	dedupColumns := []string{"column1"}
-	dedupRec, err := arrowops.DeduplicateRecord(mem, takenRec, dedupColumns, false)
-	if err != nil {
-		return nil, errs.Wrap(err, fmt.Errorf("deduplicating by columns: %v", dedupColumns))
-	}
-
-	return dedupRec, nil
+	return table2Pipeline.Transformer()(ctx, mem, logger, record)
 }
@@ -2,22 +2,63 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 
 	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
 	"github.com/alekLukanen/ChapterhouseDB-v1/operations"
+	"github.com/alekLukanen/errs"
 )
 
-func BuildTableRegistry(ctx context.Context, logger *slog.Logger) (*operations.TableRegistry, error) {
+func BuildTableRegistry(ctx context.Context, logger *slog.Logger, cfg *Config) (*operations.TableRegistry, error) {
+	return BuildTableRegistryWithHeartbeat(ctx, logger, cfg, nil)
+}
+
+// BuildTableRegistryWithHeartbeat builds the table registry with
+// subscriptions which beat the heartbeat each time they transform a
+// record; the worker's liveness check is based on it.
+func BuildTableRegistryWithHeartbeat(
+	ctx context.Context,
+	logger *slog.Logger,
+	cfg *Config,
+	heartbeat *Heartbeat,
+) (*operations.TableRegistry, error) {
 
 	tableRegistry := operations.NewTableRegistry(ctx, logger)
 
+	transformers, err := BuildTransformerRegistry()
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("failed to build the transformer registry"))
+	}
+	transformers.SetHeartbeat(heartbeat)
+
 	// add all tables here
-	tables := []*elements.Table{
-		BuildTable1(), BuildTable2(),
+	tables := make([]*elements.Table, 0)
+	for _, build := range []func(*TransformerRegistry) (*elements.Table, error){BuildTable1, BuildTable2} {
+		tbl, err := build(transformers)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, tbl)
+	}
+
+	// add the tables defined in spec files
+	if cfg.TableSpecDir != "" {
+		specTables, err := buildSpecTables(cfg.TableSpecDir, transformers, tables)
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("failed to build tables from specs in %s", cfg.TableSpecDir))
+		}
+		tables = append(tables, specTables...)
+	}
+
+	// make sure each subscription runs its transformer
+	// and produces the columns of its table
+	err = ValidateTableWiring(ctx, tables, transformers)
+	if err != nil {
+		return nil, err
 	}
 
-	err := tableRegistry.AddTables(tables...)
+	err = tableRegistry.AddTables(tables...)
 	if err != nil {
 		return nil, err
 	}
@@ -31,3 +72,35 @@ func BuildTableRegistry(ctx context.Context, logger *slog.Logger) (*operations.T
 	return tableRegistry, nil
 
 }
+
+func buildSpecTables(
+	dir string,
+	transformers *TransformerRegistry,
+	existingTables []*elements.Table,
+) ([]*elements.Table, error) {
+	specs, err := LoadTableSpecs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tableNames := make(map[string]struct{}, len(existingTables)+len(specs))
+	for _, tbl := range existingTables {
+		tableNames[tbl.TableName()] = struct{}{}
+	}
+
+	tables := make([]*elements.Table, 0, len(specs))
+	for _, spec := range specs {
+		if _, ok := tableNames[spec.Name]; ok {
+			return nil, errs.NewStackError(fmt.Errorf("%w| table: %s", ErrDuplicateTable, spec.Name))
+		}
+		tableNames[spec.Name] = struct{}{}
+
+		tbl, err := spec.BuildTable(transformers)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, tbl)
+	}
+
+	return tables, nil
+}
@@ -0,0 +1,469 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/ChapterhouseDB-v1/partitionFuncs"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+TableSpec is the file representation of an elements.Table. A spec
+can be written in YAML or JSON, for example
+
+	name: table3
+	columns:
+	  - {name: column1, type: int64}
+	  - {name: column2, type: string}
+	options:
+	  batchProcessingDelay: 1s
+	  batchProcessingSize: 5000
+	  maxObjectSize: 10000
+	partitions:
+	  - column: column1
+	    integerRange: {width: 1000}
+	subscriptionGroups:
+	  - name: group1
+	    subscriptions:
+	      - sourceName: sourceSystemTable3
+	        transformer: table1
+	        columns:
+	          - {name: column1, type: int64}
+	          - {name: column2, type: string}
+
+The optional validation section is a ValidationSpec.
+*/
+type TableSpec struct {
+	Name               string                  `yaml:"name" json:"name"`
+	Columns            []ColumnSpec            `yaml:"columns" json:"columns"`
+	Options            TableOptionsSpec        `yaml:"options" json:"options"`
+	Partitions         []PartitionSpec         `yaml:"partitions" json:"partitions"`
+	SubscriptionGroups []SubscriptionGroupSpec `yaml:"subscriptionGroups" json:"subscriptionGroups"`
+	Validation         *ValidationSpec         `yaml:"validation" json:"validation"`
+}
+
+type ColumnSpec struct {
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type"`
+}
+
+type TableOptionsSpec struct {
+	BatchProcessingDelay Duration `yaml:"batchProcessingDelay" json:"batchProcessingDelay"`
+	BatchProcessingSize  int      `yaml:"batchProcessingSize" json:"batchProcessingSize"`
+	MaxObjectSize        int      `yaml:"maxObjectSize" json:"maxObjectSize"`
+}
+
+type PartitionSpec struct {
+	Column       string            `yaml:"column" json:"column"`
+	IntegerRange *IntegerRangeSpec `yaml:"integerRange" json:"integerRange"`
+	StringHash   *StringHashSpec   `yaml:"stringHash" json:"stringHash"`
+}
+
+type IntegerRangeSpec struct {
+	Width int `yaml:"width" json:"width"`
+}
+
+type StringHashSpec struct {
+	PartitionCount int    `yaml:"partitionCount" json:"partitionCount"`
+	Method         string `yaml:"method" json:"method"`
+}
+
+type SubscriptionGroupSpec struct {
+	Name          string             `yaml:"name" json:"name"`
+	Subscriptions []SubscriptionSpec `yaml:"subscriptions" json:"subscriptions"`
+}
+
+type SubscriptionSpec struct {
+	SourceName  string       `yaml:"sourceName" json:"sourceName"`
+	Transformer string       `yaml:"transformer" json:"transformer"`
+	Columns     []ColumnSpec `yaml:"columns" json:"columns"`
+}
+
+// Duration decodes from a Go duration string such as "1s" in
+// both YAML and JSON specs.
+type Duration time.Duration
+
+func (obj *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	return obj.parse(s)
+}
+
+func (obj *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return obj.parse(s)
+}
+
+func (obj *Duration) parse(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*obj = Duration(d)
+	return nil
+}
+
+/*
+Reads every .yaml, .yml and .json file in dir as a TableSpec.
+Unknown fields are rejected so that typos in a spec fail loudly
+instead of silently falling back to zero values. The specs are
+returned in file name order.
+*/
+func LoadTableSpecs(dir string) ([]*TableSpec, error) {
+	fileNames, err := specFileNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make([]*TableSpec, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		fp := filepath.Join(dir, fileName)
+		spec, err := LoadTableSpecFile(fp)
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("failed to load table spec: %s", fp))
+		}
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+func LoadTableSpecFile(path string) (*TableSpec, error) {
+	spec := &TableSpec{}
+	err := decodeSpecFile(path, spec, ErrInvalidTableSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	err = spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// specFileNames returns the .yaml, .yml and .json files in dir in name order.
+func specFileNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+
+	fileNames := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+			fileNames = append(fileNames, entry.Name())
+		}
+	}
+	sort.Strings(fileNames)
+	return fileNames, nil
+}
+
+// decodeSpecFile decodes a YAML or JSON file into spec rejecting unknown
+// fields. Decoding errors are reported as invalidErr.
+func decodeSpecFile(path string, spec any, invalidErr error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(spec)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(spec)
+	default:
+		return errs.NewStackError(fmt.Errorf("%w| file: %s", ErrUnsupportedConfigType, path))
+	}
+	if err != nil {
+		return errs.NewStackError(fmt.Errorf("%w| %s", invalidErr, err))
+	}
+	return nil
+}
+
+// Validate reports every problem in the spec by its field path.
+func (obj *TableSpec) Validate() error {
+	problems := make([]string, 0)
+	addProblem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if strings.TrimSpace(obj.Name) == "" {
+		addProblem("name must not be empty")
+	}
+
+	columnTypes := validateColumnSpecs("columns", obj.Columns, addProblem)
+
+	if obj.Options.BatchProcessingDelay < 0 {
+		addProblem("options.batchProcessingDelay must not be negative")
+	}
+	if obj.Options.BatchProcessingSize <= 0 {
+		addProblem("options.batchProcessingSize must be greater than zero")
+	}
+	if obj.Options.MaxObjectSize <= 0 {
+		addProblem("options.maxObjectSize must be greater than zero")
+	}
+
+	for i, part := range obj.Partitions {
+		field := fmt.Sprintf("partitions[%d]", i)
+		colType, ok := columnTypes[part.Column]
+		if !ok {
+			addProblem("%s.column %q is not a table column", field, part.Column)
+		}
+		switch {
+		case part.IntegerRange != nil && part.StringHash != nil:
+			addProblem("%s must set only one of integerRange or stringHash", field)
+		case part.IntegerRange != nil:
+			if part.IntegerRange.Width <= 0 {
+				addProblem("%s.integerRange.width must be greater than zero", field)
+			}
+			if ok && !arrow.IsInteger(colType.ID()) {
+				addProblem("%s.integerRange requires an integer column, got %s", field, colType)
+			}
+		case part.StringHash != nil:
+			if part.StringHash.PartitionCount <= 0 {
+				addProblem("%s.stringHash.partitionCount must be greater than zero", field)
+			}
+			if part.StringHash.Method != "fnv" {
+				addProblem("%s.stringHash.method %q is not supported", field, part.StringHash.Method)
+			}
+			if ok && colType.ID() != arrow.STRING {
+				addProblem("%s.stringHash requires a string column, got %s", field, colType)
+			}
+		default:
+			addProblem("%s must set one of integerRange or stringHash", field)
+		}
+	}
+
+	if len(obj.SubscriptionGroups) == 0 {
+		addProblem("subscriptionGroups must not be empty")
+	}
+	for i, group := range obj.SubscriptionGroups {
+		field := fmt.Sprintf("subscriptionGroups[%d]", i)
+		if strings.TrimSpace(group.Name) == "" {
+			addProblem("%s.name must not be empty", field)
+		}
+		if len(group.Subscriptions) == 0 {
+			addProblem("%s.subscriptions must not be empty", field)
+		}
+		for j, sub := range group.Subscriptions {
+			subField := fmt.Sprintf("%s.subscriptions[%d]", field, j)
+			if strings.TrimSpace(sub.SourceName) == "" {
+				addProblem("%s.sourceName must not be empty", subField)
+			}
+			if strings.TrimSpace(sub.Transformer) == "" {
+				addProblem("%s.transformer must not be empty", subField)
+			}
+			validateColumnSpecs(subField+".columns", sub.Columns, addProblem)
+		}
+	}
+
+	if obj.Validation != nil {
+		obj.Validation.validateFields("validation.", addProblem)
+	}
+
+	if len(problems) > 0 {
+		return errs.NewStackError(
+			fmt.Errorf("%w| table %q: %s", ErrInvalidTableSpec, obj.Name, strings.Join(problems, "; ")),
+		)
+	}
+	return nil
+}
+
+func validateColumnSpecs(field string, columns []ColumnSpec, addProblem func(string, ...any)) map[string]arrow.DataType {
+	columnTypes := make(map[string]arrow.DataType, len(columns))
+	if len(columns) == 0 {
+		addProblem("%s must not be empty", field)
+	}
+	for i, col := range columns {
+		if strings.TrimSpace(col.Name) == "" {
+			addProblem("%s[%d].name must not be empty", field, i)
+			continue
+		}
+		if _, ok := columnTypes[col.Name]; ok {
+			addProblem("%s[%d].name %q is duplicated", field, i, col.Name)
+			continue
+		}
+		dtype, err := ParseArrowType(col.Type)
+		if err != nil {
+			addProblem("%s[%d].type %q is not supported", field, i, col.Type)
+			continue
+		}
+		columnTypes[col.Name] = dtype
+	}
+	return columnTypes
+}
+
+/*
+Builds the table described by the spec. Transformers are
+resolved by name from the transformer registry.
+*/
+func (obj *TableSpec) BuildTable(transformers *TransformerRegistry) (*elements.Table, error) {
+	columns, err := buildColumns(obj.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	table := elements.NewTable(obj.Name).
+		AddColumns(columns...).
+		SetOptions(
+			elements.TableOptions{
+				BatchProcessingDelay: time.Duration(obj.Options.BatchProcessingDelay),
+				BatchProcessingSize:  obj.Options.BatchProcessingSize,
+				MaxObjectSize:        obj.Options.MaxObjectSize,
+			},
+		)
+
+	partitions := make([]*elements.ColumnPartition, 0, len(obj.Partitions))
+	for _, part := range obj.Partitions {
+		switch {
+		case part.IntegerRange != nil:
+			partitions = append(partitions, elements.NewColumnPartition(
+				part.Column,
+				partitionFuncs.NewIntegerRangePartitionOptions(part.IntegerRange.Width),
+			))
+		case part.StringHash != nil:
+			partitions = append(partitions, elements.NewColumnPartition(
+				part.Column,
+				partitionFuncs.NewStringHashPartitionOptions(part.StringHash.PartitionCount, partitionFuncs.MethodFNVHash),
+			))
+		}
+	}
+	table.AddColumnPartitions(partitions...)
+
+	for _, groupSpec := range obj.SubscriptionGroups {
+		group := elements.NewSubscriptionGroup(groupSpec.Name)
+		for _, subSpec := range groupSpec.Subscriptions {
+			transformer, err := transformers.Subscribe(obj.Name, subSpec.SourceName, subSpec.Transformer, partitions)
+			if err != nil {
+				return nil, err
+			}
+			subColumns, err := buildColumns(subSpec.Columns)
+			if err != nil {
+				return nil, err
+			}
+			group.AddSubscriptions(
+				elements.NewExternalSubscription(
+					subSpec.SourceName,
+					transformer,
+					subColumns,
+				),
+			)
+		}
+		table.AddSubscriptionGroups(group)
+	}
+
+	return table, nil
+}
+
+func buildColumns(specs []ColumnSpec) ([]elements.Column, error) {
+	columns := make([]elements.Column, 0, len(specs))
+	for _, spec := range specs {
+		dtype, err := ParseArrowType(spec.Type)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, elements.NewColumn(spec.Name, dtype))
+	}
+	return columns, nil
+}
+
+/*
+ParseArrowType maps the type names used in table specs to arrow types.
+Besides the primitive names it accepts decimal128(precision, scale) and
+list<element type>, for example list<int64>.
+*/
+func ParseArrowType(name string) (arrow.DataType, error) {
+	lowerName := strings.ToLower(strings.TrimSpace(name))
+
+	if elemName, ok := strings.CutPrefix(lowerName, "list<"); ok {
+		elemName, ok = strings.CutSuffix(elemName, ">")
+		if !ok {
+			return nil, errs.NewStackError(fmt.Errorf("%w| type: %s", ErrUnsupportedColumnType, name))
+		}
+		elemType, err := ParseArrowType(elemName)
+		if err != nil {
+			return nil, err
+		}
+		return arrow.ListOf(elemType), nil
+	}
+
+	if args, ok := strings.CutPrefix(lowerName, "decimal128("); ok {
+		var precision, scale int32
+		_, err := fmt.Sscanf(args, "%d,%d)", &precision, &scale)
+		if err != nil {
+			return nil, errs.NewStackError(fmt.Errorf("%w| type: %s", ErrUnsupportedColumnType, name))
+		}
+		if precision < 1 || precision > 38 || scale < 0 || scale > precision {
+			return nil, errs.NewStackError(fmt.Errorf("%w| type: %s", ErrUnsupportedColumnType, name))
+		}
+		return &arrow.Decimal128Type{Precision: precision, Scale: scale}, nil
+	}
+
+	switch lowerName {
+	case "bool", "boolean":
+		return arrow.FixedWidthTypes.Boolean, nil
+	case "int8":
+		return arrow.PrimitiveTypes.Int8, nil
+	case "int16":
+		return arrow.PrimitiveTypes.Int16, nil
+	case "int32":
+		return arrow.PrimitiveTypes.Int32, nil
+	case "int64":
+		return arrow.PrimitiveTypes.Int64, nil
+	case "uint8":
+		return arrow.PrimitiveTypes.Uint8, nil
+	case "uint16":
+		return arrow.PrimitiveTypes.Uint16, nil
+	case "uint32":
+		return arrow.PrimitiveTypes.Uint32, nil
+	case "uint64":
+		return arrow.PrimitiveTypes.Uint64, nil
+	case "float32":
+		return arrow.PrimitiveTypes.Float32, nil
+	case "float64":
+		return arrow.PrimitiveTypes.Float64, nil
+	case "string", "utf8":
+		return arrow.BinaryTypes.String, nil
+	case "binary":
+		return arrow.BinaryTypes.Binary, nil
+	case "date32":
+		return arrow.FixedWidthTypes.Date32, nil
+	case "date64":
+		return arrow.FixedWidthTypes.Date64, nil
+	case "timestamp_s":
+		return arrow.FixedWidthTypes.Timestamp_s, nil
+	case "timestamp_ms":
+		return arrow.FixedWidthTypes.Timestamp_ms, nil
+	case "timestamp_us":
+		return arrow.FixedWidthTypes.Timestamp_us, nil
+	case "timestamp_ns":
+		return arrow.FixedWidthTypes.Timestamp_ns, nil
+	default:
+		return nil, errs.NewStackError(fmt.Errorf("%w| type: %s", ErrUnsupportedColumnType, name))
+	}
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+/*
+Checks that every subscription of every table runs the transformer it
+was built with and that its output matches the table.
+
+Subscriptions must be built with TransformerRegistry.Subscribe, which
+records the name of the transformer each one runs; a subscription the
+registry did not build is reported. The named transformer is then run
+against a record with one row of zero values in the subscription's
+source columns, and the output schema must have the table's columns in
+the same name, order and type, so a table wired to the transformer of
+another table with other columns is reported. All problems are
+collected into a single error so that one startup reports every broken
+subscription.
+*/
+func ValidateTableWiring(ctx context.Context, tables []*elements.Table, transformers *TransformerRegistry) error {
+	mem := memory.NewGoAllocator()
+	// the transformers log on every call which would only add noise here
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	problems := make([]string, 0)
+	for _, table := range tables {
+		for _, group := range table.SubscriptionGroups() {
+			for _, sub := range group.Subscriptions() {
+				transformer, err := boundTransformer(table, sub, transformers)
+				if err == nil {
+					err = validateSubscriptionWiring(ctx, mem, logger, table, sub, transformer)
+				}
+				if err != nil {
+					problems = append(
+						problems,
+						fmt.Sprintf("table %q, source %q: %s", table.TableName(), sub.SourceName(), err),
+					)
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errs.NewStackError(
+			fmt.Errorf("%w| %d subscription(s) failed:\n- %s", ErrTableWiring, len(problems), strings.Join(problems, "\n- ")),
+		)
+	}
+	return nil
+}
+
+// boundTransformer returns the registered transformer the subscription
+// was built with.
+func boundTransformer(table *elements.Table, sub elements.Subscription, transformers *TransformerRegistry) (Transformer, error) {
+	if sub.Transformer() == nil {
+		return nil, fmt.Errorf("transformer is nil")
+	}
+	name, ok := transformers.BoundTransformer(table.TableName(), sub.SourceName())
+	if !ok {
+		return nil, fmt.Errorf("the subscription was not built with the transformer registry")
+	}
+	transformer, err := transformers.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("transformer %q is not registered", name)
+	}
+	return transformer, nil
+}
+
+func validateSubscriptionWiring(
+	ctx context.Context,
+	mem *memory.GoAllocator,
+	logger *slog.Logger,
+	table *elements.Table,
+	sub elements.Subscription,
+	transformer Transformer,
+) (retErr error) {
+	// a transformer which can't handle the sample record should
+	// be reported like any other wiring problem
+	defer func() {
+		if r := recover(); r != nil {
+			retErr = fmt.Errorf("transformer panicked on a sample record: %v", r)
+		}
+	}()
+
+	sampleRec := sampleRecord(mem, sub.Columns())
+	defer sampleRec.Release()
+
+	outRec, err := transformer(ctx, mem, logger, sampleRec)
+	if err != nil {
+		return fmt.Errorf("transformer failed on a sample record: %s", err)
+	}
+	if outRec == nil {
+		return fmt.Errorf("transformer returned a nil record")
+	}
+	defer outRec.Release()
+
+	return compareSchemaToColumns(outRec.Schema(), table.Columns())
+}
+
+// sampleRecord builds a record with one row of zero values in the columns.
+func sampleRecord(mem *memory.GoAllocator, columns []elements.Column) arrow.Record {
+	fields := make([]arrow.Field, len(columns))
+	for i, col := range columns {
+		fields[i] = arrow.Field{Name: col.Name, Type: col.Dtype, Nullable: true}
+	}
+	recBuilder := array.NewRecordBuilder(mem, arrow.NewSchema(fields, nil))
+	defer recBuilder.Release()
+	for _, fieldBuilder := range recBuilder.Fields() {
+		fieldBuilder.AppendEmptyValue()
+	}
+	return recBuilder.NewRecord()
+}
+
+func compareSchemaToColumns(schema *arrow.Schema, columns []elements.Column) error {
+	mismatches := make([]string, 0)
+
+	if schema.NumFields() != len(columns) {
+		mismatches = append(
+			mismatches,
+			fmt.Sprintf("transformer returned %d columns, table declares %d", schema.NumFields(), len(columns)),
+		)
+	}
+
+	for i := 0; i < max(schema.NumFields(), len(columns)); i++ {
+		switch {
+		case i >= schema.NumFields():
+			mismatches = append(mismatches, fmt.Sprintf("column %d: missing, expected %s %s", i, columns[i].Name, columns[i].Dtype))
+		case i >= len(columns):
+			field := schema.Field(i)
+			mismatches = append(mismatches, fmt.Sprintf("column %d: unexpected %s %s", i, field.Name, field.Type))
+		default:
+			field := schema.Field(i)
+			if field.Name != columns[i].Name || !arrow.TypeEqual(field.Type, columns[i].Dtype) {
+				mismatches = append(
+					mismatches,
+					fmt.Sprintf("column %d: got %s %s, expected %s %s", i, field.Name, field.Type, columns[i].Name, columns[i].Dtype),
+				)
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("output schema does not match the table: %s", strings.Join(mismatches, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// testTable builds table1 or table2 with their registered transformers.
+func testTable(t *testing.T, name string) *elements.Table {
+	t.Helper()
+	transformers, err := BuildTransformerRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := BuildTable1
+	if name == "table2" {
+		build = BuildTable2
+	}
+	table, err := build(transformers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestValidateTableWiring(t *testing.T) {
+	transformers, err := BuildTransformerRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	table1, err := BuildTable1(transformers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table2, err := BuildTable2(transformers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ValidateTableWiring(context.Background(), []*elements.Table{table1, table2}, transformers)
+	if err != nil {
+		t.Fatalf("ValidateTableWiring: %v", err)
+	}
+}
+
+func TestValidateTableWiringReports(t *testing.T) {
+	transformers, err := BuildTransformerRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not built with the registry
+	unbound := elements.NewTable("unbound").
+		AddColumns(testTable(t, "table1").Columns()...).
+		AddSubscriptionGroups(elements.NewSubscriptionGroup("group1").AddSubscriptions(
+			elements.NewExternalSubscription("sourceSystemTable1", Table1Transformer, Table1SourceColumns()),
+		))
+
+	// the table1 transformer doesn't produce the table's columns
+	transformer, err := transformers.Subscribe("mismatched", "sourceSystemTable1", "table1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatched := elements.NewTable("mismatched").
+		AddColumns(elements.NewColumn("column1", arrow.PrimitiveTypes.Int64)).
+		AddSubscriptionGroups(elements.NewSubscriptionGroup("group1").AddSubscriptions(
+			elements.NewExternalSubscription("sourceSystemTable1", transformer, Table1SourceColumns()),
+		))
+
+	for _, table := range []*elements.Table{unbound, mismatched} {
+		t.Run(table.TableName(), func(t *testing.T) {
+			err := ValidateTableWiring(context.Background(), []*elements.Table{table}, transformers)
+			if !errors.Is(err, ErrTableWiring) {
+				t.Fatalf("got %v, want ErrTableWiring", err)
+			}
+		})
+	}
+}
+
+func TestTransformerRegistrySubscribe(t *testing.T) {
+	transformers, err := BuildTransformerRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transformers.Subscribe("table3", "source3", "missing", nil)
+	if !errors.Is(err, ErrTransformerNotFound) {
+		t.Fatalf("subscribing to a missing transformer: got %v, want ErrTransformerNotFound", err)
+	}
+
+	_, err = transformers.Subscribe("table3", "source3", "table1", nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if name, ok := transformers.BoundTransformer("table3", "source3"); !ok || name != "table1" {
+		t.Fatalf("BoundTransformer = %q, %v, want table1", name, ok)
+	}
+	_, err = transformers.Subscribe("table3", "source3", "table2", nil)
+	if !errors.Is(err, ErrTableWiring) {
+		t.Fatalf("subscribing twice: got %v, want ErrTableWiring", err)
+	}
+}
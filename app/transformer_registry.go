@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// Transformer has the signature expected by elements.NewExternalSubscription.
+type Transformer = func(
+	ctx context.Context,
+	mem *memory.GoAllocator,
+	logger *slog.Logger,
+	record arrow.Record,
+) (arrow.Record, error)
+
+/*
+TransformerRegistry holds the transformers by name. Subscriptions are
+built with Subscribe, which records the name of the transformer each
+subscription runs so that ValidateTableWiring can check it.
+*/
+type TransformerRegistry struct {
+	transformers map[string]Transformer
+	// transformer names by subscriptionKey
+	bindings map[string]string
+	// beaten on each call of a subscribed transformer, if set
+	heartbeat *Heartbeat
+}
+
+func NewTransformerRegistry() *TransformerRegistry {
+	return &TransformerRegistry{
+		transformers: make(map[string]Transformer),
+		bindings:     make(map[string]string),
+	}
+}
+
+/*
+Builds the registry of every transformer known to the app. To make a
+new transformer available to table specs add a Register call here.
+*/
+func BuildTransformerRegistry() (*TransformerRegistry, error) {
+	registry := NewTransformerRegistry()
+
+	transformers := []struct {
+		name        string
+		transformer Transformer
+	}{
+		{"table1", Table1Transformer},
+		{"table2", Table2Transformer},
+	}
+	for _, t := range transformers {
+		err := registry.Register(t.name, t.transformer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+func (obj *TransformerRegistry) Register(name string, transformer Transformer) error {
+	if name == "" {
+		return errs.NewStackError(fmt.Errorf("%w| name must not be empty", ErrInvalidTransformer))
+	}
+	if transformer == nil {
+		return errs.NewStackError(fmt.Errorf("%w| transformer %q is nil", ErrInvalidTransformer, name))
+	}
+	if _, ok := obj.transformers[name]; ok {
+		return errs.NewStackError(fmt.Errorf("%w| transformer: %s", ErrDuplicateTransformer, name))
+	}
+	obj.transformers[name] = transformer
+	return nil
+}
+
+func (obj *TransformerRegistry) Get(name string) (Transformer, error) {
+	transformer, ok := obj.transformers[name]
+	if !ok {
+		return nil, errs.NewStackError(
+			fmt.Errorf("%w| transformer %q; registered transformers: %v", ErrTransformerNotFound, name, obj.Names()),
+		)
+	}
+	return transformer, nil
+}
+
+/*
+Returns the named transformer instrumented for the subscription of
+tableName to sourceName, and records that the subscription runs it.
+A table can only subscribe to a source once.
+*/
+func (obj *TransformerRegistry) Subscribe(
+	tableName string,
+	sourceName string,
+	name string,
+	partitions []*elements.ColumnPartition,
+) (Transformer, error) {
+	transformer, err := obj.Get(name)
+	if err != nil {
+		return nil, errs.Wrap(err, fmt.Errorf("table %q, source %q", tableName, sourceName))
+	}
+	key := subscriptionKey(tableName, sourceName)
+	if _, ok := obj.bindings[key]; ok {
+		return nil, errs.NewStackError(fmt.Errorf("%w| table %q subscribes to source %q twice", ErrTableWiring, tableName, sourceName))
+	}
+	obj.bindings[key] = name
+
+	instrumented := InstrumentTransformer(tableName, sourceName, partitions, transformer)
+	heartbeat := obj.heartbeat
+	if heartbeat == nil {
+		return instrumented, nil
+	}
+	return func(
+		ctx context.Context,
+		mem *memory.GoAllocator,
+		logger *slog.Logger,
+		record arrow.Record,
+	) (arrow.Record, error) {
+		heartbeat.Beat()
+		defer heartbeat.Beat()
+		return instrumented(ctx, mem, logger, record)
+	}, nil
+}
+
+// SetHeartbeat makes the transformers of subscriptions built afterwards
+// beat the heartbeat when they start and when they return.
+func (obj *TransformerRegistry) SetHeartbeat(heartbeat *Heartbeat) {
+	obj.heartbeat = heartbeat
+}
+
+// BoundTransformer returns the name of the transformer the subscription
+// of tableName to sourceName was built with by Subscribe.
+func (obj *TransformerRegistry) BoundTransformer(tableName, sourceName string) (string, bool) {
+	name, ok := obj.bindings[subscriptionKey(tableName, sourceName)]
+	return name, ok
+}
+
+func subscriptionKey(tableName, sourceName string) string {
+	return tableName + "/" + sourceName
+}
+
+func (obj *TransformerRegistry) Names() []string {
+	names := make([]string, 0, len(obj.transformers))
+	for name := range obj.transformers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,271 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alekLukanen/errs"
+)
+
+const (
+	ReportFormatText = "text"
+	ReportFormatJSON = "json"
+)
+
+// KeySample is how many keys fell into a finding, with some of them.
+type KeySample struct {
+	Count   int64    `json:"count"`
+	Samples []string `json:"samples,omitempty"`
+}
+
+// FileSample is how many files fell into a finding, with some of them.
+type FileSample struct {
+	Count   int64    `json:"count"`
+	Samples []string `json:"samples,omitempty"`
+}
+
+func NewFileSample(files []string, size int) FileSample {
+	return FileSample{Count: int64(len(files)), Samples: files[:min(len(files), size)]}
+}
+
+type DuplicatedKey struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+type ValueMismatch struct {
+	Key      string `json:"key"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+type ColumnMismatch struct {
+	Column  string          `json:"column"`
+	Count   int64           `json:"count"`
+	Samples []ValueMismatch `json:"samples,omitempty"`
+}
+
+type PartitionRows struct {
+	Partition string `json:"partition"`
+	Rows      int64  `json:"rows"`
+}
+
+type AssertionResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ValidationTiming struct {
+	Step    string  `json:"step"`
+	Seconds float64 `json:"seconds"`
+}
+
+/*
+What the validation of one table found. Expected rows are the newest
+row of each key in the generated data, leaving out the dead-lettered
+rows; actual rows are the rows of the table state in object storage.
+Missing keys are expected but not in the table, extra keys are in
+the table but not expected, and column mismatches count the keys in
+both whose values differ. The row bounds and assertions come from the
+table's ValidationSpec.
+
+The table is read from the files its manifests reference. Orphan files
+are part files no manifest references; they are reported but do not
+fail the validation since superseded files are removed asynchronously.
+Missing files are referenced but do not exist, which does.
+*/
+type ValidationReport struct {
+	Table          string `json:"table"`
+	ExpectedRows   int64  `json:"expectedRows"`
+	ActualRows     int64  `json:"actualRows"`
+	DeadLetterRows int64  `json:"deadLetterRows"`
+	// the dead-letter file ends in a record cut short by a crash
+	DeadLetterTruncated bool `json:"deadLetterTruncated,omitempty"`
+	// rows which were inserted but are missing from the insert journal
+	UnjournaledRows int64 `json:"unjournaledRows,omitempty"`
+
+	DuplicatedKeyCount int64            `json:"duplicatedKeyCount"`
+	DuplicatedKeys     []DuplicatedKey  `json:"duplicatedKeys,omitempty"`
+	MissingKeys        KeySample        `json:"missingKeys"`
+	ExtraKeys          KeySample        `json:"extraKeys"`
+	ColumnMismatches   []ColumnMismatch `json:"columnMismatches,omitempty"`
+	Partitions         []PartitionRows  `json:"partitions,omitempty"`
+
+	ManifestPartitions int        `json:"manifestPartitions"`
+	ManifestFiles      int        `json:"manifestFiles"`
+	OrphanFiles        FileSample `json:"orphanFiles"`
+	MissingFiles       FileSample `json:"missingFiles"`
+
+	MinRows    *int64            `json:"minRows,omitempty"`
+	MaxRows    *int64            `json:"maxRows,omitempty"`
+	Assertions []AssertionResult `json:"assertions,omitempty"`
+
+	Timings []ValidationTiming `json:"timings,omitempty"`
+
+	startedAt time.Time
+}
+
+func NewValidationReport(tableName string) *ValidationReport {
+	return &ValidationReport{Table: tableName, startedAt: time.Now()}
+}
+
+// Time adds a timing for step measured from the previous call, or from
+// the creation of the report for the first step.
+func (obj *ValidationReport) Time(step string) {
+	now := time.Now()
+	obj.Timings = append(obj.Timings, ValidationTiming{Step: step, Seconds: now.Sub(obj.startedAt).Seconds()})
+	obj.startedAt = now
+}
+
+// Elapsed returns the sum of every timing.
+func (obj *ValidationReport) Elapsed() time.Duration {
+	total := 0.0
+	for _, timing := range obj.Timings {
+		total += timing.Seconds
+	}
+	return time.Duration(total * float64(time.Second))
+}
+
+func (obj *ValidationReport) Passed() bool {
+	return len(obj.Problems()) == 0
+}
+
+// Problems returns one line per failed check; it is empty when the report passed.
+func (obj *ValidationReport) Problems() []string {
+	problems := make([]string, 0)
+	if obj.DuplicatedKeyCount > 0 {
+		problems = append(problems, fmt.Sprintf("%d keys are duplicated", obj.DuplicatedKeyCount))
+	}
+	if obj.MissingKeys.Count > 0 {
+		problems = append(problems, fmt.Sprintf("%d expected keys are missing", obj.MissingKeys.Count))
+	}
+	if obj.ExtraKeys.Count > 0 {
+		problems = append(problems, fmt.Sprintf("%d keys were not expected", obj.ExtraKeys.Count))
+	}
+	for _, mismatch := range obj.ColumnMismatches {
+		if mismatch.Count > 0 {
+			problems = append(problems, fmt.Sprintf("%d keys have a different %s", mismatch.Count, mismatch.Column))
+		}
+	}
+	if obj.MissingFiles.Count > 0 {
+		problems = append(problems, fmt.Sprintf("%d files referenced by the manifests do not exist", obj.MissingFiles.Count))
+	}
+	if obj.DeadLetterRows > 0 {
+		problems = append(problems, fmt.Sprintf("%d rows were never inserted", obj.DeadLetterRows))
+	}
+	if obj.DeadLetterTruncated {
+		problems = append(problems, "the dead-letter file ends in a truncated record whose rows are not counted")
+	}
+	if obj.UnjournaledRows > 0 {
+		problems = append(problems, fmt.Sprintf("%d inserted rows are missing from the insert journal and were not expected", obj.UnjournaledRows))
+	}
+	if obj.MinRows != nil && obj.ActualRows < *obj.MinRows {
+		problems = append(problems, fmt.Sprintf("the table has %d rows, fewer than the minimum of %d", obj.ActualRows, *obj.MinRows))
+	}
+	if obj.MaxRows != nil && obj.ActualRows > *obj.MaxRows {
+		problems = append(problems, fmt.Sprintf("the table has %d rows, more than the maximum of %d", obj.ActualRows, *obj.MaxRows))
+	}
+	for _, assertion := range obj.Assertions {
+		switch {
+		case assertion.Error != "":
+			problems = append(problems, fmt.Sprintf("assertion %q failed to run: %s", assertion.Name, assertion.Error))
+		case !assertion.Passed:
+			problems = append(problems, fmt.Sprintf("assertion %q is false", assertion.Name))
+		}
+	}
+	return problems
+}
+
+func (obj *ValidationReport) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+	return data, nil
+}
+
+func (obj *ValidationReport) Text() string {
+	var sb strings.Builder
+
+	status := "PASSED"
+	if !obj.Passed() {
+		status = "FAILED"
+	}
+	fmt.Fprintf(&sb, "validation of table %s %s in %s\n", obj.Table, status, obj.Elapsed().Round(time.Millisecond))
+	fmt.Fprintf(&sb, "  rows: %d expected, %d actual, %d dead-lettered\n", obj.ExpectedRows, obj.ActualRows, obj.DeadLetterRows)
+	fmt.Fprintf(
+		&sb, "  files: %d in %d partition manifests, %d orphaned\n",
+		obj.ManifestFiles, obj.ManifestPartitions, obj.OrphanFiles.Count,
+	)
+	for _, problem := range obj.Problems() {
+		fmt.Fprintf(&sb, "  problem: %s\n", problem)
+	}
+
+	if len(obj.DuplicatedKeys) > 0 {
+		sb.WriteString("  duplicated keys:\n")
+		for _, dup := range obj.DuplicatedKeys {
+			fmt.Fprintf(&sb, "    %s x%d\n", dup.Key, dup.Count)
+		}
+	}
+	if len(obj.MissingKeys.Samples) > 0 {
+		fmt.Fprintf(&sb, "  missing keys: %s\n", strings.Join(obj.MissingKeys.Samples, ", "))
+	}
+	if len(obj.ExtraKeys.Samples) > 0 {
+		fmt.Fprintf(&sb, "  extra keys: %s\n", strings.Join(obj.ExtraKeys.Samples, ", "))
+	}
+	if len(obj.MissingFiles.Samples) > 0 {
+		fmt.Fprintf(&sb, "  missing files: %s\n", strings.Join(obj.MissingFiles.Samples, ", "))
+	}
+	if len(obj.OrphanFiles.Samples) > 0 {
+		fmt.Fprintf(&sb, "  orphan files: %s\n", strings.Join(obj.OrphanFiles.Samples, ", "))
+	}
+	for _, mismatch := range obj.ColumnMismatches {
+		if len(mismatch.Samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "  %s mismatches:\n", mismatch.Column)
+		for _, sample := range mismatch.Samples {
+			fmt.Fprintf(&sb, "    key %s: expected %s, got %s\n", sample.Key, sample.Expected, sample.Actual)
+		}
+	}
+
+	if len(obj.Assertions) > 0 {
+		sb.WriteString("  assertions:\n")
+		for _, assertion := range obj.Assertions {
+			result := "passed"
+			if !assertion.Passed {
+				result = "failed"
+			}
+			fmt.Fprintf(&sb, "    %s: %s\n", assertion.Name, result)
+		}
+	}
+	if len(obj.Partitions) > 0 {
+		sb.WriteString("  rows per partition:\n")
+		for _, partition := range obj.Partitions {
+			fmt.Fprintf(&sb, "    %s: %d\n", partition.Partition, partition.Rows)
+		}
+	}
+	if len(obj.Timings) > 0 {
+		sb.WriteString("  timings:\n")
+		for _, timing := range obj.Timings {
+			fmt.Fprintf(&sb, "    %s: %s\n", timing.Step, time.Duration(timing.Seconds*float64(time.Second)).Round(time.Millisecond))
+		}
+	}
+
+	return sb.String()
+}
+
+// Render returns the report in the given format.
+func (obj *ValidationReport) Render(format string) ([]byte, error) {
+	switch format {
+	case ReportFormatText:
+		return []byte(obj.Text()), nil
+	case ReportFormatJSON:
+		return obj.JSON()
+	default:
+		return nil, errs.NewStackError(fmt.Errorf("%w| %s", ErrUnsupportedReportFormat, format))
+	}
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/ChapterhouseDB-v1/operations"
+	"github.com/alekLukanen/errs"
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+/*
+How the tester checks a table end to end. The expected rows are the
+newest generated row of each key, ordered by OrderColumn; without an
+order column every key must be sent once, and a key sent more than
+once fails with ErrAmbiguousNewestRow. CompareColumns are compared
+between the expected rows and the table state, float columns within
+FloatTolerance. MinRows and MaxRows bound the rows of the table state.
+Each assertion is a DuckDB query over the views expected and actual
+which must return a single true value, for example
+
+	validation:
+	  keyColumns: [column1]
+	  orderColumn: sampleId
+	  floatTolerance: 0.0001
+	  minRows: 1
+	  assertions:
+	    - name: no negative ids
+	      sql: SELECT count(*) = 0 FROM actual WHERE column1 < 0
+
+Empty key columns default to the first partition column of the table
+and empty compare columns to every other table column which the
+table's source also has.
+*/
+type ValidationSpec struct {
+	KeyColumns     []string              `yaml:"keyColumns" json:"keyColumns"`
+	CompareColumns []string              `yaml:"compareColumns" json:"compareColumns"`
+	OrderColumn    string                `yaml:"orderColumn" json:"orderColumn"`
+	FloatTolerance float64               `yaml:"floatTolerance" json:"floatTolerance"`
+	MinRows        *int64                `yaml:"minRows" json:"minRows"`
+	MaxRows        *int64                `yaml:"maxRows" json:"maxRows"`
+	Assertions     []ValidationAssertion `yaml:"assertions" json:"assertions"`
+}
+
+type ValidationAssertion struct {
+	Name string `yaml:"name" json:"name"`
+	SQL  string `yaml:"sql" json:"sql"`
+}
+
+// the validation of the tables defined in Go; spec tables carry theirs
+var tableValidationSpecs = map[string]func() ValidationSpec{
+	"table1": Table1ValidationSpec,
+	"table2": Table2ValidationSpec,
+}
+
+/*
+Returns the resolved validation spec of every table in the registry,
+taken from the Go definitions, the validation section of the table
+specs in cfg.TableSpecDir, or the defaults.
+*/
+func BuildValidationSpecs(cfg *Config, tableRegistry *operations.TableRegistry) (map[string]ValidationSpec, error) {
+	specs := make(map[string]ValidationSpec)
+	for name, build := range tableValidationSpecs {
+		specs[name] = build()
+	}
+
+	if cfg.TableSpecDir != "" {
+		tableSpecs, err := LoadTableSpecs(cfg.TableSpecDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, tableSpec := range tableSpecs {
+			if tableSpec.Validation != nil {
+				specs[tableSpec.Name] = *tableSpec.Validation
+			}
+		}
+	}
+
+	resolved := make(map[string]ValidationSpec, len(specs))
+	for _, table := range tableRegistry.Tables() {
+		spec, err := specs[table.TableName()].Resolve(table)
+		if err != nil {
+			return nil, err
+		}
+		resolved[table.TableName()] = spec
+	}
+	return resolved, nil
+}
+
+/*
+Returns the spec with its defaults filled in after checking that
+every column it names exists. Keys and compared columns must be
+table columns the source also has; the order column only has to be a
+source column since it is usually dropped by the transformer.
+*/
+func (obj ValidationSpec) Resolve(table *elements.Table) (ValidationSpec, error) {
+	problems := make([]string, 0)
+	addProblem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	obj.validateFields("", addProblem)
+
+	comparable := SourceTableColumns(table)
+	comparableNames := make([]string, len(comparable))
+	for i, col := range comparable {
+		comparableNames[i] = col.Name
+	}
+	sourceNames := make([]string, 0)
+	for _, col := range table.SubscriptionGroups()[0].Subscriptions()[0].Columns() {
+		sourceNames = append(sourceNames, col.Name)
+	}
+
+	resolved := obj
+	if len(resolved.KeyColumns) == 0 {
+		switch {
+		case len(table.ColumnPartitions()) > 0:
+			resolved.KeyColumns = []string{table.ColumnPartitions()[0].Name()}
+		case len(comparableNames) > 0:
+			resolved.KeyColumns = slices.Clone(comparableNames[:1])
+		}
+	}
+	if len(resolved.KeyColumns) == 0 {
+		addProblem("keyColumns must not be empty")
+	}
+	for _, name := range resolved.KeyColumns {
+		if !slices.Contains(comparableNames, name) {
+			addProblem("key column %q is not a column of both the table and its source", name)
+		}
+	}
+
+	if len(resolved.CompareColumns) == 0 {
+		for _, name := range comparableNames {
+			if !slices.Contains(resolved.KeyColumns, name) {
+				resolved.CompareColumns = append(resolved.CompareColumns, name)
+			}
+		}
+	}
+	for _, name := range resolved.CompareColumns {
+		if !slices.Contains(comparableNames, name) {
+			addProblem("compare column %q is not a column of both the table and its source", name)
+		}
+	}
+
+	if resolved.OrderColumn != "" && !slices.Contains(sourceNames, resolved.OrderColumn) {
+		addProblem("order column %q is not a source column", resolved.OrderColumn)
+	}
+
+	if len(problems) > 0 {
+		return ValidationSpec{}, errs.NewStackError(
+			fmt.Errorf("%w| table %q: %s", ErrInvalidValidationSpec, table.TableName(), strings.Join(problems, "; ")),
+		)
+	}
+	return resolved, nil
+}
+
+// validateFields checks what can be checked without the table.
+func (obj ValidationSpec) validateFields(field string, addProblem func(string, ...any)) {
+	if obj.FloatTolerance < 0 {
+		addProblem("%sfloatTolerance must not be negative", field)
+	}
+	if obj.MinRows != nil && *obj.MinRows < 0 {
+		addProblem("%sminRows must not be negative", field)
+	}
+	if obj.MinRows != nil && obj.MaxRows != nil && *obj.MinRows > *obj.MaxRows {
+		addProblem("%sminRows must not be greater than maxRows", field)
+	}
+	names := make(map[string]struct{}, len(obj.Assertions))
+	for i, assertion := range obj.Assertions {
+		if strings.TrimSpace(assertion.Name) == "" {
+			addProblem("%sassertions[%d].name must not be empty", field, i)
+		} else if _, ok := names[assertion.Name]; ok {
+			addProblem("%sassertions[%d].name %q is duplicated", field, i, assertion.Name)
+		}
+		names[assertion.Name] = struct{}{}
+		if strings.TrimSpace(assertion.SQL) == "" {
+			addProblem("%sassertions[%d].sql must not be empty", field, i)
+		}
+	}
+}
+
+// FloatColumns returns the compare columns holding floating point values.
+func (obj ValidationSpec) FloatColumns(table *elements.Table) map[string]bool {
+	floats := make(map[string]bool)
+	for _, col := range table.Columns() {
+		if !slices.Contains(obj.CompareColumns, col.Name) {
+			continue
+		}
+		switch col.Dtype.ID() {
+		case arrow.FLOAT16, arrow.FLOAT32, arrow.FLOAT64:
+			floats[col.Name] = true
+		}
+	}
+	return floats
+}
+
+// SourceTableColumns returns the table columns which the first source
+// of the table also has, which are the ones a dataset generates.
+func SourceTableColumns(table *elements.Table) []elements.Column {
+	sourceColumns := make(map[string]bool)
+	for _, col := range table.SubscriptionGroups()[0].Subscriptions()[0].Columns() {
+		sourceColumns[col.Name] = true
+	}
+	columns := make([]elements.Column, 0, len(table.Columns()))
+	for _, col := range table.Columns() {
+		if sourceColumns[col.Name] {
+			columns = append(columns, col)
+		}
+	}
+	return columns
+}
@@ -4,33 +4,39 @@ import (
 	"context"
 	"errors"
 	"log/slog"
-	"time"
 
+	"github.com/alekLukanen/ChapterhouseDB-v1/operations"
 	"github.com/alekLukanen/ChapterhouseDB-v1/storage"
-	"github.com/alekLukanen/ChapterhouseDB-v1/tasker"
 	"github.com/alekLukanen/ChapterhouseDB-v1/warehouse"
 	"github.com/alekLukanen/errs"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
-func BuildWarehouse(ctx context.Context, logger *slog.Logger) (*warehouse.Warehouse, error) {
-	// create the test bucket
-	objStorOpts := storage.ObjectStorageOptions{
-		Endpoint:     "http://chdb-minio-api:9000",
-		Region:       "us-west-2",
-		AuthKey:      "minioadmin",
-		AuthSecret:   "minioadmin",
-		UsePathStyle: true,
-		AuthType:     storage.ObjectStorageAuthTypeStatic,
+func BuildWarehouse(ctx context.Context, logger *slog.Logger, cfg *Config) (*warehouse.Warehouse, error) {
+	tableRegistry, err := BuildTableRegistry(ctx, logger, cfg)
+	if err != nil {
+		logger.Error("failed to build table registry", slog.String("error", errs.ErrorWithStack(err)))
+		return nil, err
 	}
-	objectStorage, err := storage.NewObjectStorage(ctx, logger, objStorOpts)
+
+	return BuildWarehouseWithRegistry(ctx, logger, cfg, tableRegistry)
+}
+
+func BuildWarehouseWithRegistry(
+	ctx context.Context,
+	logger *slog.Logger,
+	cfg *Config,
+	tableRegistry *operations.TableRegistry,
+) (*warehouse.Warehouse, error) {
+	// create the test bucket
+	objectStorage, err := storage.NewObjectStorage(ctx, logger, cfg.ObjectStorageOptions())
 	if err != nil {
 		logger.Error("failed to create object storage struct", slog.String("error", err.Error()))
 		return nil, err
 	}
 
-	err = objectStorage.CreateBucket(ctx, "chdb-test-warehouse")
+	err = objectStorage.CreateBucket(ctx, cfg.Manifest.BucketName)
 	if err != nil {
 		var ifErr *types.BucketAlreadyOwnedByYou
 		if errors.As(err, &ifErr) {
@@ -41,40 +47,15 @@ func BuildWarehouse(ctx context.Context, logger *slog.Logger) (*warehouse.Wareho
 		}
 	}
 
-	tableRegistry, err := BuildTableRegistry(ctx, logger)
-	if err != nil {
-		logger.Error("failed to build table registry", slog.String("error", errs.ErrorWithStack(err)))
-		return nil, err
-	}
-
 	warehouse, err := warehouse.NewWarehouse(
 		ctx,
 		logger,
-		"warehouse1",
+		cfg.WarehouseName,
 		tableRegistry,
-		storage.KeyStorageOptions{
-			Address:   "chdb-keydb:6379",
-			Password:  "",
-			KeyPrefix: "chapterhouseDB",
-		},
-		storage.ObjectStorageOptions{
-			Endpoint:     "http://chdb-minio-api:9000",
-			Region:       "us-west-2",
-			AuthKey:      "minioadmin",
-			AuthSecret:   "minioadmin",
-			UsePathStyle: true,
-			AuthType:     storage.ObjectStorageAuthTypeStatic,
-		},
-		storage.ManifestStorageOptions{
-			BucketName: "chdb-test-warehouse",
-			KeyPrefix:  "chdb",
-		},
-		tasker.Options{
-			KeyDBAddress:  "chdb-keydb:6379",
-			KeyDBPassword: "",
-			KeyPrefix:     "chapterhouseDB",
-			TaskTimeout:   1 * time.Minute,
-		},
+		cfg.KeyStorageOptions(),
+		cfg.ObjectStorageOptions(),
+		cfg.ManifestStorageOptions(),
+		cfg.TaskerOptions(),
 	)
 	if err != nil {
 		logger.Error("failed to create warehouse", slog.String("error", err.Error()))
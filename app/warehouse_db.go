@@ -0,0 +1,189 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/alekLukanen/ChapterhouseDB-v1/elements"
+	"github.com/alekLukanen/ChapterhouseDB-v1/operations"
+	"github.com/alekLukanen/errs"
+	"github.com/jmoiron/sqlx"
+)
+
+/*
+Opens an in-memory DuckDB database which can read the warehouse's
+object storage through cfg.ObjectStorage.QueryEndpoint. The duckdb
+driver needs cgo, so app leaves registering it to the commands which
+query the warehouse:
+
+	import _ "github.com/marcboeker/go-duckdb"
+*/
+func OpenWarehouseDB(cfg *Config) (*sqlx.DB, error) {
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return nil, errs.NewStackError(err)
+	}
+
+	// register the s3 credentials
+	_, err = db.Exec(fmt.Sprintf(`
+  INSTALL httpfs;
+  LOAD httpfs;
+  create secret locals3mock3 (
+    TYPE S3,
+    KEY_ID '%s',
+    SECRET '%s',
+    ENDPOINT '%s',
+    URL_STYLE 'path',
+    USE_SSL false
+  );`,
+		cfg.ObjectStorage.AuthKey,
+		cfg.ObjectStorage.AuthSecret,
+		cfg.ObjectStorage.QueryEndpoint,
+	))
+	if err != nil {
+		db.Close()
+		return nil, errs.NewStackError(err)
+	}
+
+	return sqlx.NewDb(db, "duckdb"), nil
+}
+
+// TableView is the view of a table and how many of its files it reads.
+type TableView struct {
+	Table            string
+	Partitions       int
+	Files            int
+	PrunedPartitions int
+	PrunedFiles      int
+}
+
+/*
+Creates a view named after each table in the registry over the files
+its manifests currently reference. A table without files has nothing
+to read yet, so it gets no view and a warning instead.
+
+The predicates restrict the view of their table to the matching rows,
+and its files to the partitions which can hold them; the others are
+pruned. Partitions are only pruned once VerifyPartitionLayout found
+the table's partitions under the keys this package computes for them.
+When every partition is pruned the view keeps the columns of the table
+but has no rows.
+*/
+func CreateTableViews(
+	ctx context.Context,
+	logger *slog.Logger,
+	db *sqlx.DB,
+	manifests *ManifestReader,
+	tableRegistry *operations.TableRegistry,
+	predicates []PartitionPredicate,
+) ([]TableView, error) {
+	for _, predicate := range predicates {
+		_, err := tableRegistry.GetTable(predicate.Table)
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("%w| %s", ErrInvalidPartitionPredicate, predicate))
+		}
+	}
+
+	views := make([]TableView, 0)
+	for _, table := range tableRegistry.Tables() {
+		files, err := manifests.TableFiles(ctx, table.TableName())
+		if err != nil {
+			return nil, err
+		}
+		if len(files.Files) == 0 {
+			logger.Warn("the table has no files yet", slog.String("table", table.TableName()))
+			continue
+		}
+
+		conditions := make([]string, 0)
+		scanned := files
+		for _, predicate := range predicates {
+			if predicate.Table != table.TableName() {
+				continue
+			}
+			match, err := predicate.Matcher(table)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, predicate.SQL())
+
+			prune, err := layoutVerified(ctx, logger, manifests, table, predicate.Column, files)
+			if err != nil {
+				return nil, err
+			}
+			if prune {
+				scanned = scanned.Prune(match)
+			}
+		}
+
+		query := fmt.Sprintf("SELECT * FROM %s", scanned.ReadParquet("union_by_name = true"))
+		if len(scanned.Files) == 0 {
+			// one file gives the columns of the table
+			onlyColumns := &TableFiles{Bucket: files.Bucket, Files: files.Files[:1]}
+			query = fmt.Sprintf("SELECT * FROM %s WHERE false", onlyColumns.ReadParquet())
+		} else if len(conditions) > 0 {
+			query = fmt.Sprintf("%s WHERE %s", query, strings.Join(conditions, " AND "))
+		}
+
+		_, err = db.ExecContext(ctx, fmt.Sprintf(
+			`CREATE OR REPLACE VIEW "%s" AS %s`,
+			strings.ReplaceAll(table.TableName(), `"`, `""`),
+			query,
+		))
+		if err != nil {
+			return nil, errs.Wrap(err, fmt.Errorf("creating the view of table %s", table.TableName()))
+		}
+		views = append(views, TableView{
+			Table:            table.TableName(),
+			Partitions:       len(scanned.Partitions),
+			Files:            len(scanned.Files),
+			PrunedPartitions: len(files.Partitions) - len(scanned.Partitions),
+			PrunedFiles:      len(files.Files) - len(scanned.Files),
+		})
+	}
+	return views, nil
+}
+
+// partitionLayoutSample is how many partitions of a table are checked
+// before their keys are used to prune.
+const partitionLayoutSample = 3
+
+// layoutVerified returns whether the partition keys of the column can
+// be trusted to prune the files of the table. A layout which could not
+// be verified only logs a warning; the predicate's condition still
+// filters the rows.
+func layoutVerified(
+	ctx context.Context,
+	logger *slog.Logger,
+	manifests *ManifestReader,
+	table *elements.Table,
+	column string,
+	files *TableFiles,
+) (bool, error) {
+	var partition *elements.ColumnPartition
+	for _, part := range table.ColumnPartitions() {
+		if part.Name() == column {
+			partition = part
+		}
+	}
+	if partition == nil {
+		return false, nil
+	}
+
+	verified, err := VerifyPartitionLayout(ctx, manifests, partition, files.Partitions, partitionLayoutSample)
+	if err != nil {
+		return false, errs.Wrap(err, fmt.Errorf("table %s", table.TableName()))
+	}
+	if verified == 0 {
+		logger.Warn(
+			"could not verify the partition layout, not pruning",
+			slog.String("table", table.TableName()),
+			slog.String("column", column),
+		)
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/marcboeker/go-duckdb"
+
+	"github.com/alekLukanen/ChapterhouseDB-example-app/app"
+)
+
+const (
+	formatTable   = "table"
+	formatCSV     = "csv"
+	formatJSON    = "json"
+	formatParquet = "parquet"
+)
+
+var errUnsupportedFormat = errors.New("unsupported output format")
+
+// stringsFlag collects every value of a repeatable flag.
+type stringsFlag []string
+
+func (obj *stringsFlag) String() string {
+	if obj == nil {
+		return ""
+	}
+	return strings.Join(*obj, ",")
+}
+
+func (obj *stringsFlag) Set(value string) error {
+	*obj = append(*obj, value)
+	return nil
+}
+
+/*
+Runs SQL against the warehouse tables with an embedded DuckDB. Each
+table in the registry is a view over the files its manifests currently
+reference, so
+
+	query -e "select count(*) from table1"
+
+reads exactly what the workers wrote. Without -e or -f the statements
+are read from stdin, which is interactive on a terminal.
+
+A -where predicate on a partition column limits the view of its table
+to the partitions which can hold matching rows:
+
+	query -where "table1.column1 BETWEEN 1000 AND 2999" -e "select * from table1"
+*/
+func main() {
+
+	configPath := flag.String("config", os.Getenv(app.ConfigPathEnvVar), "path to a YAML or TOML config file")
+	execute := flag.String("e", "", "the SQL statements to run")
+	file := flag.String("f", "", "a file of SQL statements to run")
+	format := flag.String("format", formatTable, "the output format: table, csv, json or parquet")
+	output := flag.String("o", "", "write the results to this file instead of stdout; required for parquet")
+	verbose := flag.Bool("v", false, "log the progress of creating the table views")
+	var wheres stringsFlag
+	flag.Var(&wheres, "where", "a predicate on a partition column, such as 'table1.column1 IN (1, 2)'; repeatable")
+	flag.Parse()
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelInfo
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	interactive := *execute == "" && *file == ""
+	err := checkFormat(*format, *output, interactive)
+	if err != nil {
+		logger.Error("invalid output", slog.String("error", err.Error()))
+		os.Exit(2)
+	}
+
+	predicates := make([]app.PartitionPredicate, 0, len(wheres))
+	for _, where := range wheres {
+		predicate, err := app.ParsePartitionPredicate(where)
+		if err != nil {
+			logger.Error("invalid -where predicate", slog.String("error", err.Error()))
+			os.Exit(2)
+		}
+		predicates = append(predicates, predicate)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	cfg, err := app.LoadConfig(*configPath)
+	if err != nil {
+		logger.Error("unable to load the config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	tableRegistry, err := app.BuildTableRegistry(ctx, logger, cfg)
+	if err != nil {
+		logger.Error("unable to create the table registry", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	xdb, err := app.OpenWarehouseDB(cfg)
+	if err != nil {
+		logger.Error("unable to open duckdb", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	defer xdb.Close()
+
+	views, err := app.CreateTableViews(ctx, logger, xdb, app.NewManifestReader(cfg), tableRegistry, predicates)
+	if err != nil {
+		logger.Error("unable to create the table views", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	for _, view := range views {
+		logger.Info(
+			"created the table view",
+			slog.String("table", view.Table),
+			slog.Int("partitions", view.Partitions),
+			slog.Int("files", view.Files),
+		)
+		if view.PrunedPartitions > 0 {
+			fmt.Fprintf(os.Stderr, "%s: pruned %s\n", view.Table, describePruning(view))
+		}
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" && *format != formatParquet {
+		outFile, err := os.Create(*output)
+		if err != nil {
+			logger.Error("unable to create the output file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer outFile.Close()
+		out = outFile
+	}
+	runner := &queryRunner{db: xdb, out: out, format: *format, output: *output}
+
+	switch {
+	case !interactive:
+		script := *execute
+		if *file != "" {
+			data, err := os.ReadFile(*file)
+			if err != nil {
+				logger.Error("unable to read the SQL file", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			script = string(data)
+		}
+		err = runner.RunScript(ctx, script)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	default:
+		err = runner.RunInteractive(ctx, os.Stdin, views)
+		if err != nil {
+			logger.Error("reading the statements failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+}
+
+// checkFormat rejects the output options which can't be written. Parquet
+// is written from the last statement of a script, so it needs -e or -f.
+func checkFormat(format string, output string, interactive bool) error {
+	switch format {
+	case formatTable, formatCSV, formatJSON:
+		return nil
+	case formatParquet:
+		if output == "" {
+			return fmt.Errorf("%w: parquet needs an output file (-o)", errUnsupportedFormat)
+		}
+		if interactive {
+			return fmt.Errorf("%w: parquet needs the statements from -e or -f", errUnsupportedFormat)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedFormat, format)
+	}
+}
+
+func describePruning(view app.TableView) string {
+	return fmt.Sprintf(
+		"%d of %d partitions (%d of %d files)",
+		view.PrunedPartitions, view.Partitions+view.PrunedPartitions,
+		view.PrunedFiles, view.Files+view.PrunedFiles,
+	)
+}
+
+type queryRunner struct {
+	db     *sqlx.DB
+	out    io.Writer
+	format string
+	output string
+}
+
+/*
+Runs each statement of the script in order and stops at the first
+which fails. For parquet the last statement is the query written to
+the output file and the ones before it only run.
+*/
+func (obj *queryRunner) RunScript(ctx context.Context, script string) error {
+	statements, rest := splitStatements(script)
+	if rest != "" {
+		statements = append(statements, rest)
+	}
+	for i, statement := range statements {
+		var err error
+		if obj.format == formatParquet && i == len(statements)-1 {
+			err = obj.copyToParquet(ctx, statement)
+		} else {
+			err = obj.run(ctx, statement)
+		}
+		if err != nil {
+			return fmt.Errorf("%s\n%w", statement, err)
+		}
+	}
+	return nil
+}
+
+/*
+Reads statements from in until it ends, running each once its
+terminating semicolon was read. Lines starting with a dot are
+commands:
+
+	.tables          list the table views
+	.format <name>   change the output format
+	.quit            exit
+
+A prompt is shown when in is a terminal.
+*/
+func (obj *queryRunner) RunInteractive(ctx context.Context, in io.Reader, views []app.TableView) error {
+	prompt := false
+	if f, ok := in.(*os.File); ok {
+		info, err := f.Stat()
+		prompt = err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+	showPrompt := func(continued bool) {
+		if !prompt {
+			return
+		}
+		if continued {
+			fmt.Fprint(os.Stderr, "   ...> ")
+		} else {
+			fmt.Fprint(os.Stderr, "chdb> ")
+		}
+	}
+
+	var pending strings.Builder
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	showPrompt(false)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if pending.Len() == 0 && strings.HasPrefix(trimmed, ".") {
+			fields := strings.Fields(trimmed)
+			switch fields[0] {
+			case ".quit", ".exit":
+				return nil
+			case ".tables":
+				for _, view := range views {
+					fmt.Fprintf(obj.out, "%s: %d files in %d partitions", view.Table, view.Files, view.Partitions)
+					if view.PrunedPartitions > 0 {
+						fmt.Fprintf(obj.out, ", pruned %s", describePruning(view))
+					}
+					fmt.Fprintln(obj.out)
+				}
+			case ".format":
+				if len(fields) != 2 || fields[1] == formatParquet {
+					fmt.Fprintln(os.Stderr, "usage: .format table|csv|json")
+				} else if err := checkFormat(fields[1], "", true); err != nil {
+					fmt.Fprintln(os.Stderr, err.Error())
+				} else {
+					obj.format = fields[1]
+				}
+			default:
+				fmt.Fprintf(os.Stderr, "unknown command %s\n", fields[0])
+			}
+			showPrompt(false)
+			continue
+		}
+
+		pending.WriteString(line)
+		pending.WriteString("\n")
+		statements, rest := splitStatements(pending.String())
+		for _, statement := range statements {
+			// a failed statement is reported and the session goes on
+			err := obj.run(ctx, statement)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+		pending.Reset()
+		if rest != "" {
+			pending.WriteString(rest)
+			pending.WriteString("\n")
+		}
+		showPrompt(pending.Len() > 0)
+	}
+	return scanner.Err()
+}
+
+// run executes the statement and writes the rows it returns.
+func (obj *queryRunner) run(ctx context.Context, statement string) error {
+	start := time.Now()
+	rows, err := obj.db.QueryxContext(ctx, statement)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([][]any, 0)
+	for rows.Next() {
+		row, err := rows.SliceScan()
+		if err != nil {
+			return err
+		}
+		values = append(values, row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	switch obj.format {
+	case formatCSV:
+		return writeCSV(obj.out, columns, values)
+	case formatJSON:
+		return writeJSON(obj.out, columns, values)
+	default:
+		err = writeTable(obj.out, columns, values)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(obj.out, "(%d rows in %s)\n", len(values), time.Since(start).Round(time.Millisecond))
+		return err
+	}
+}
+
+// copyToParquet has DuckDB write the rows of the query to the output file.
+func (obj *queryRunner) copyToParquet(ctx context.Context, query string) error {
+	_, err := obj.db.ExecContext(ctx, fmt.Sprintf(
+		"COPY (%s) TO '%s' (FORMAT PARQUET)",
+		query, strings.ReplaceAll(obj.output, "'", "''"),
+	))
+	return err
+}
+
+func writeTable(w io.Writer, columns []string, values [][]any) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	dashes := make([]string, len(columns))
+	for i, column := range columns {
+		dashes[i] = strings.Repeat("-", max(len(column), 4))
+	}
+	fmt.Fprintln(tw, strings.Join(dashes, "\t"))
+	for _, row := range values {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = formatValue(value, "NULL")
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, columns []string, values [][]any) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(columns)
+	if err != nil {
+		return err
+	}
+	for _, row := range values {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = formatValue(value, "")
+		}
+		err = cw.Write(cells)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSON writes one object per row, keyed by the column names.
+func writeJSON(w io.Writer, columns []string, values [][]any) error {
+	encoder := json.NewEncoder(w)
+	for _, row := range values {
+		object := make(map[string]any, len(columns))
+		for i, value := range row {
+			object[columns[i]] = jsonValue(value)
+		}
+		err := encoder.Encode(object)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatValue(value any, null string) string {
+	switch v := value.(type) {
+	case nil:
+		return null
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case duckdb.Decimal:
+		return strconv.FormatFloat(v.Float64(), 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// jsonValue keeps the values JSON can represent and formats the rest.
+func jsonValue(value any) any {
+	switch v := value.(type) {
+	case nil, bool, string, time.Time,
+		int8, int16, int32, int64, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case duckdb.Decimal:
+		return v.Float64()
+	default:
+		if _, err := json.Marshal(v); err == nil {
+			return v
+		}
+		return formatValue(v, "")
+	}
+}
+
+/*
+Splits a script into its statements at the semicolons outside of
+quotes and comments. The statements are trimmed and those without
+any SQL are dropped. rest is the text after the last semicolon when
+it holds SQL, an unterminated statement.
+*/
+func splitStatements(script string) (statements []string, rest string) {
+	statements = make([]string, 0)
+	var current strings.Builder
+	var quote rune
+	lineComment, blockComment, hasSQL := false, false, false
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case lineComment:
+			lineComment = r != '\n'
+		case blockComment:
+			if r == '*' && next == '/' {
+				blockComment = false
+				current.WriteRune(r)
+				r = next
+				i++
+			}
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '-' && next == '-':
+			lineComment = true
+		case r == '/' && next == '*':
+			blockComment = true
+		case r == ';':
+			if hasSQL {
+				statements = append(statements, strings.TrimSpace(current.String()))
+			}
+			current.Reset()
+			hasSQL = false
+			continue
+		default:
+			if r == '\'' || r == '"' {
+				quote = r
+			}
+			hasSQL = hasSQL || !unicode.IsSpace(r)
+		}
+		current.WriteRune(r)
+	}
+	if hasSQL {
+		rest = strings.TrimSpace(current.String())
+	}
+	return statements, rest
+}
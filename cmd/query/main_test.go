@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		statements []string
+		rest       string
+	}{
+		{"empty", "", []string{}, ""},
+		{"one statement", "select 1;", []string{"select 1"}, ""},
+		{"several statements", "select 1; select 2;\nselect 3;", []string{"select 1", "select 2", "select 3"}, ""},
+		{"unterminated", "select 1; select 2", []string{"select 1"}, "select 2"},
+		{"empty statements", " ; ;\n;select 1;;", []string{"select 1"}, ""},
+		{"semicolon in a string", "select ';' as s;", []string{"select ';' as s"}, ""},
+		{"escaped quote", "select 'it''s; fine';", []string{"select 'it''s; fine'"}, ""},
+		{"semicolon in an identifier", `select 1 as "a;b";`, []string{`select 1 as "a;b"`}, ""},
+		{"semicolon in a line comment", "select 1 -- not; the end\n;", []string{"select 1 -- not; the end"}, ""},
+		{"semicolon in a block comment", "select /* a; b */ 1;", []string{"select /* a; b */ 1"}, ""},
+		{"only a comment", "-- nothing here\n", []string{}, ""},
+		{"unterminated string", "select 'a; b", []string{}, "select 'a; b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statements, rest := splitStatements(tt.script)
+			if !reflect.DeepEqual(statements, tt.statements) || rest != tt.rest {
+				t.Fatalf("splitStatements(%q) = %q, %q, want %q, %q", tt.script, statements, rest, tt.statements, tt.rest)
+			}
+		})
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		output      string
+		interactive bool
+		err         error
+	}{
+		{"table", formatTable, "", true, nil},
+		{"csv to a file", formatCSV, "out.csv", true, nil},
+		{"parquet script", formatParquet, "out.parquet", false, nil},
+		{"parquet without a file", formatParquet, "", false, errUnsupportedFormat},
+		{"interactive parquet", formatParquet, "out.parquet", true, errUnsupportedFormat},
+		{"unknown format", "xml", "", false, errUnsupportedFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkFormat(tt.format, tt.output, tt.interactive)
+			if tt.err == nil && err != nil {
+				t.Fatalf("checkFormat: %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
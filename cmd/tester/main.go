@@ -3,12 +3,20 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/apache/arrow/go/v17/arrow"
 	"github.com/apache/arrow/go/v17/arrow/memory"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/marcboeker/go-duckdb"
@@ -16,239 +24,873 @@ import (
 	"github.com/alekLukanen/ChapterhouseDB-example-app/app"
 	"github.com/alekLukanen/ChapterhouseDB-v1/operations"
 	"github.com/alekLukanen/ChapterhouseDB-v1/storage"
-	"github.com/alekLukanen/ChapterhouseDB-v1/tasker"
-	arrowops "github.com/alekLukanen/arrow-ops"
 	"github.com/alekLukanen/errs"
 )
 
-type DBValidationResp struct {
-	IsValid bool `db:"is_valid"`
+// validationSampleSize is how many examples a validation finding lists.
+const validationSampleSize = 10
+
+/*
+Maps a table name to the dataset generator used to fill it. The flag
+is repeatable: -dataset table1=random-table1 -dataset table2=random-table2
+*/
+type datasetFlag struct {
+	tables     []string
+	generators map[string]string
 }
 
-type DBValidationMismatchResp struct {
-	IsValid       bool `db:"is_valid"`
-	MismatchCount int  `db:"mismatch_count"`
+func (obj *datasetFlag) String() string {
+	if obj == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(obj.tables))
+	for _, table := range obj.tables {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", table, obj.generators[table]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (obj *datasetFlag) Set(value string) error {
+	table, generator, ok := strings.Cut(value, "=")
+	if !ok || table == "" || generator == "" {
+		return fmt.Errorf("expected table=generator, got %q", value)
+	}
+	if obj.generators == nil {
+		obj.generators = make(map[string]string)
+	}
+	if _, exists := obj.generators[table]; !exists {
+		obj.tables = append(obj.tables, table)
+	}
+	obj.generators[table] = generator
+	return nil
+}
+
+// stringsFlag collects every value of a repeatable flag.
+type stringsFlag []string
+
+func (obj *stringsFlag) String() string {
+	if obj == nil {
+		return ""
+	}
+	return strings.Join(*obj, ",")
+}
+
+func (obj *stringsFlag) Set(value string) error {
+	*obj = append(*obj, value)
+	return nil
 }
 
 func main() {
 
+	defaultParams := app.DefaultDatasetParams()
+
+	var datasets datasetFlag
+	var files stringsFlag
+	configPath := flag.String("config", os.Getenv(app.ConfigPathEnvVar), "path to a YAML or TOML config file")
+	flag.Var(&datasets, "dataset", fmt.Sprintf(
+		"table=generator pair selecting the dataset for a table; repeatable (generators: %s)",
+		strings.Join(app.DatasetNames(), ", "),
+	))
+	rowsPerRecord := flag.Int("rows-per-record", defaultParams.RowsPerRecord, "rows in each generated record")
+	maxIdValue := flag.Int("max-id", defaultParams.MaxIdValue, "upper bound of the generated id range")
+	maxIterations := flag.Int("iterations", defaultParams.MaxIterations, "number of records each dataset generates")
+	seed := flag.Uint64("seed", defaultParams.Seed, "random seed shared by every dataset")
+	inRecordUpdateRatio := flag.Float64("in-record-update-ratio", 0, "share of rows which update a key from the same record")
+	versionColumn := flag.String("version-column", "sampleId", "column which orders the versions of a key")
+	flag.Var(&files, "file", "file or glob replayed by the files dataset; repeatable")
+	checkpointDir := flag.String("checkpoint-dir", "", "directory for dataset checkpoints; a restarted tester resumes from them")
+	load := flag.Bool("load", false, "insert with the rate-controlled load generator instead of one record per second")
+	loadRate := flag.Float64("load-rate", 0, "target rows per second of the load generator; 0 inserts as fast as possible")
+	loadConcurrency := flag.Int("load-concurrency", 1, "number of concurrent inserters of the load generator")
+	loadRampUp := flag.Duration("load-ramp-up", 0, "time the load generator takes to reach the target rate")
+	loadRampDown := flag.Duration("load-ramp-down", 0, "time the load generator takes to fall back to zero before the duration ends")
+	loadDuration := flag.Duration("load-duration", 0, "time limit of the load generator per table; 0 runs until the dataset ends")
+	loadBurstInterval := flag.Duration("load-burst-interval", 0, "time between the starts of load bursts; 0 disables bursts")
+	loadBurstDuration := flag.Duration("load-burst-duration", 0, "length of each load burst")
+	loadBurstMultiplier := flag.Float64("load-burst-multiplier", 2, "factor applied to the target rate during a burst")
+	defaultRetry := app.DefaultRetryPolicy()
+	insertAttempts := flag.Int("insert-attempts", defaultRetry.MaxAttempts, "attempts per record before it is written to the dead-letter file")
+	insertBackoff := flag.Duration("insert-backoff", defaultRetry.InitialBackoff, "wait before the first insert retry; doubles with every retry")
+	insertMaxBackoff := flag.Duration("insert-max-backoff", defaultRetry.MaxBackoff, "longest wait between insert retries")
+	deadLetterDir := flag.String("dead-letter-dir", "dead-letters", "directory for the Arrow IPC files of records which could not be inserted")
+	journalDir := flag.String("journal-dir", "insert-journal", "directory keeping a parquet copy of every inserted record per table")
+	completionTimeout := flag.Duration("completion-timeout", 10*time.Minute, "longest wait for the warehouse to reflect the inserted data of a table")
+	reportFormat := flag.String("report-format", app.ReportFormatText, "format of the validation reports: text or json")
+	reportFile := flag.String("report-file", "", "file the validation reports are also written to")
+	exitWhenDone := flag.Bool("exit-when-done", false, "exit once validation passed instead of waiting forever")
+	completionPoll := flag.Duration("completion-poll", 2*time.Second, "interval between checks of the warehouse while waiting for a table")
+	flag.Parse()
+
+	if len(datasets.tables) == 0 {
+		datasets.Set("table1=random-table1")
+		datasets.Set("table2=random-table2")
+	}
+	params := app.DatasetParams{
+		RowsPerRecord: *rowsPerRecord,
+		MaxIdValue:    *maxIdValue,
+		MaxIterations: *maxIterations,
+		Seed:          *seed,
+		Files:         files,
+		Workload: app.UpdateWorkload{
+			InRecordUpdateRatio: *inRecordUpdateRatio,
+			VersionColumn:       *versionColumn,
+		},
+	}
+
 	logger := slog.New(slog.NewJSONHandler(
 		os.Stdout,
 		&slog.HandlerOptions{Level: slog.LevelDebug},
 	))
 	logger.Info("Running ChapterhouseDB Example App")
 
+	if *reportFormat != app.ReportFormatText && *reportFormat != app.ReportFormatJSON {
+		logger.Error("unsupported report format", slog.String("reportFormat", *reportFormat))
+		os.Exit(1)
+	}
+
+	retryPolicy := defaultRetry
+	retryPolicy.MaxAttempts = *insertAttempts
+	retryPolicy.InitialBackoff = *insertBackoff
+	retryPolicy.MaxBackoff = *insertMaxBackoff
+	if err := retryPolicy.Validate(); err != nil {
+		logger.Error("invalid insert retry flags", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(*deadLetterDir, 0o755); err != nil {
+		logger.Error("unable to create the dead-letter directory", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	var loadProfile *app.LoadProfile
+	if *load {
+		loadProfile = &app.LoadProfile{
+			RowsPerSecond:   *loadRate,
+			Concurrency:     *loadConcurrency,
+			RampUp:          *loadRampUp,
+			RampDown:        *loadRampDown,
+			Duration:        *loadDuration,
+			BurstInterval:   *loadBurstInterval,
+			BurstDuration:   *loadBurstDuration,
+			BurstMultiplier: *loadBurstMultiplier,
+		}
+		if err := loadProfile.Validate(); err != nil {
+			logger.Error("invalid load generator flags", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		// concurrent inserts finish out of order, so a checkpoint taken
+		// after one of them could skip records which are still in flight
+		if *checkpointDir != "" {
+			logger.Error("the load generator does not support -checkpoint-dir")
+			os.Exit(1)
+		}
+		// and the versions of a key could reach the warehouse in
+		// another order than they were generated in
+		if loadProfile.Concurrency > 1 && params.Workload.Enabled() {
+			logger.Error("the load generator does not support -load-concurrency above 1 with an update workload")
+			os.Exit(1)
+		}
+	}
+
+	cfg, err := app.LoadConfig(*configPath)
+	if err != nil {
+		logger.Error("unable to load the config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
 	ctx := context.Background()
 
-	tableRegistry, err := app.BuildTableRegistry(ctx, logger)
+	if cfg.Metrics.Address != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", app.MetricsHandler())
+		metricsServer := app.StartHTTPServer(logger, "metrics", cfg.Metrics.Address, metricsMux)
+		defer metricsServer.Close()
+	}
+
+	tableRegistry, err := app.BuildTableRegistry(ctx, logger, cfg)
 	if err != nil {
 		logger.Error("unable to create the table registry", slog.String("error", err.Error()))
 		return
 	}
 
-	table1Dataset := app.NewMediumRandomTable1Dataset()
-	IntsertTupleOnInterval(
-		ctx,
-		logger,
-		tableRegistry,
-		1*time.Second,
-		table1Dataset,
-		"table1",
-	)
+	validationSpecs, err := app.BuildValidationSpecs(cfg, tableRegistry)
+	if err != nil {
+		logger.Error("unable to build the validation specs", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	// an update workload sends keys more than once
+	for _, tableName := range datasets.tables {
+		if params.Workload.Enabled() && validationSpecs[tableName].OrderColumn == "" {
+			logger.Error("an update workload requires an orderColumn in the validation spec", slog.String("table", tableName))
+			os.Exit(1)
+		}
+	}
+	manifests := app.NewManifestReader(cfg)
 
-	logger.Info("waiting for data to finish processing...")
-	time.Sleep(10 * time.Second)
+	if cfg.DatasetSpecDir != "" {
+		names, err := app.RegisterDatasetSpecs(cfg.DatasetSpecDir)
+		if err != nil {
+			logger.Error("unable to register the dataset specs", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		logger.Info("registered dataset specs", slog.Any("datasets", names))
+	}
 
-	table2Dataset := app.NewMediumRandomTable2Dataset()
-	IntsertTupleOnInterval(
-		ctx,
-		logger,
-		tableRegistry,
-		1*time.Second,
-		table2Dataset,
-		"table2",
-	)
+	journals := make(map[string]*app.InsertJournal, len(datasets.tables))
+	for _, tableName := range datasets.tables {
+		dataset, err := newTableDataset(tableRegistry, tableName, datasets.generators[tableName], params)
+		if err != nil {
+			logger.Error("unable to create the dataset", slog.String("table", tableName), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
 
-	table1Dataset = app.NewMediumRandomTable1Dataset()
-	err = ValidateData(ctx, logger, table1Dataset, "table1")
-	if err != nil {
-		logger.Error("data validation failed", slog.String("error", err.Error()))
-	} else {
-		logger.Info("the data was properly written to the warehouse")
+		checkpointPath := ""
+		restored := false
+		if *checkpointDir != "" {
+			checkpointPath = filepath.Join(*checkpointDir, fmt.Sprintf("%s.checkpoint.json", tableName))
+			restored, err = restoreDataset(logger, dataset, checkpointPath)
+			if err != nil {
+				logger.Error("unable to restore the dataset", slog.String("table", tableName), slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+
+		// a resumed run keeps the dead letters and the journal of the run it continues
+		deadLetters := app.NewDeadLetterFile(deadLetterPath(*deadLetterDir, tableName), tableName)
+		journal, err := app.NewInsertJournal(filepath.Join(*journalDir, tableName))
+		if err != nil {
+			logger.Error("unable to create the insert journal", slog.String("table", tableName), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		if !restored {
+			err = os.Remove(deadLetters.Path())
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.Error("unable to remove the old dead-letter file", slog.String("table", tableName), slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			err = journal.Reset()
+			if err != nil {
+				logger.Error("unable to reset the insert journal", slog.String("table", tableName), slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+		journals[tableName] = journal
+
+		if loadProfile != nil {
+			InsertTuplesWithLoad(ctx, logger, cfg, tableRegistry, *loadProfile, retryPolicy, dataset, tableName, deadLetters, journal)
+		} else {
+			IntsertTupleOnInterval(
+				ctx,
+				logger,
+				cfg,
+				tableRegistry,
+				1*time.Second,
+				retryPolicy,
+				dataset,
+				tableName,
+				checkpointPath,
+				deadLetters,
+				journal,
+			)
+		}
+		if rows := deadLetters.Rows(); rows > 0 {
+			logger.Warn(
+				"some records could not be inserted",
+				slog.String("table", tableName),
+				slog.Int64("rows", rows),
+				slog.String("deadLetterFile", deadLetters.Path()),
+			)
+		}
+		dataset.Close()
+
+		err = WaitForTable(
+			ctx,
+			logger,
+			cfg,
+			tableRegistry,
+			manifests,
+			validationSpecs[tableName],
+			tableName,
+			journal,
+			*completionTimeout,
+			*completionPoll,
+		)
+		if err != nil {
+			logger.Error("the warehouse did not reflect the inserted data", slog.String("table", tableName), slog.String("error", err.Error()))
+		} else {
+			logger.Info("the warehouse reflects the inserted data", slog.String("table", tableName))
+		}
+	}
+
+	reports := make([]*app.ValidationReport, 0, len(datasets.tables))
+	passed := true
+	for _, tableName := range datasets.tables {
+		report, err := ValidateData(
+			ctx,
+			logger,
+			cfg,
+			tableRegistry,
+			manifests,
+			validationSpecs[tableName],
+			journals[tableName],
+			tableName,
+			deadLetterPath(*deadLetterDir, tableName),
+		)
+		if err != nil {
+			logger.Error("unable to validate the data", slog.String("table", tableName), slog.String("error", err.Error()))
+			passed = false
+			continue
+		}
+
+		reports = append(reports, report)
+		if report.Passed() {
+			logger.Info("the data was properly written to the warehouse", slog.String("table", tableName))
+		} else {
+			passed = false
+			logger.Error("data validation failed", slog.String("table", tableName), slog.Any("problems", report.Problems()))
+		}
 	}
 
-	table2Dataset = app.NewMediumRandomTable2Dataset()
-	err = ValidateData(ctx, logger, table2Dataset, "table2")
+	err = writeValidationReports(os.Stdout, reports, *reportFormat)
 	if err != nil {
-		logger.Error("data validation failed", slog.String("error", err.Error()))
-	} else {
-		logger.Info("the data was properly written to the warehouse")
+		logger.Error("unable to write the validation reports", slog.String("error", err.Error()))
+		passed = false
+	}
+	if *reportFile != "" {
+		err = writeValidationReportFile(*reportFile, reports, *reportFormat)
+		if err != nil {
+			logger.Error("unable to write the validation report file", slog.String("error", err.Error()))
+			passed = false
+		}
 	}
 
+	if !passed {
+		logger.Error("the test failed")
+		os.Exit(1)
+	}
+	if *exitWhenDone {
+		return
+	}
 	for {
 		logger.Info("done running the test; waiting forever...")
 		time.Sleep(5 * time.Second)
 	}
 }
 
-func ValidateData(ctx context.Context, logger *slog.Logger, dataset app.Dataset, tableName string) error {
+// writeValidationReports writes the reports as one JSON array or as
+// the text of each report.
+func writeValidationReports(w io.Writer, reports []*app.ValidationReport, format string) error {
+	if format == app.ReportFormatJSON {
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
 
-	logger.Info("waiting for the data to finish processing......")
+	for _, report := range reports {
+		data, err := report.Render(format)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// wait for the data to finish processing
-	warehouse, err := app.BuildWarehouse(ctx, logger)
+func writeValidationReportFile(path string, reports []*app.ValidationReport, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = writeValidationReports(f, reports, format)
 	if err != nil {
-		logger.Error("warehouse creation failed", slog.String("error", err.Error()))
+		f.Close()
 		return err
 	}
+	return f.Close()
+}
 
-	itersEmpty := 0
-	for {
-		ln, err := warehouse.Tasker.QueueLength(ctx, "tuple-processing")
-		if err != nil {
-			logger.Error("unable to get the length of the tuple processing queue", slog.String("error", err.Error()))
-		} else {
-			logger.Info(fmt.Sprintf("tuple-processing queue length: %d", ln))
-		}
+// newTableDataset creates the named dataset for a table in the registry.
+func newTableDataset(
+	tableRegistry *operations.TableRegistry,
+	tableName string,
+	datasetName string,
+	params app.DatasetParams,
+) (app.StreamingDataset, error) {
+	table, err := tableRegistry.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	params.Table = table
+	return app.NewDataset(datasetName, params)
+}
 
-		if ln == 0 {
-			itersEmpty++
-		}
-		if itersEmpty == 3 {
-			logger.Info("the producer has stopped producing data; breaking out of wait loop")
-			break
-		}
-		time.Sleep(1 * time.Second)
+func deadLetterPath(dir string, tableName string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.deadletter.arrows", tableName))
+}
+
+// restoreDataset resumes the dataset from the checkpoint at path if one
+// exists and reports whether it did.
+func restoreDataset(logger *slog.Logger, dataset app.StreamingDataset, path string) (bool, error) {
+	resumable, ok := dataset.(app.ResumableDataset)
+	if !ok {
+		return false, fmt.Errorf("the dataset %T can not be checkpointed", dataset)
+	}
+
+	checkpoint, err := app.LoadDatasetCheckpoint(path)
+	if err != nil {
+		return false, err
+	}
+	if checkpoint == nil {
+		return false, nil
 	}
 
-	time.Sleep(3 * time.Second)
+	err = resumable.Restore(checkpoint)
+	if err != nil {
+		return false, err
+	}
+	logger.Info(
+		"resuming the dataset from its checkpoint",
+		slog.String("path", path),
+		slog.Int("iterationsCompleted", checkpoint.IterationsCompleted),
+	)
+	return true, nil
+}
+
+/*
+Compares the table state in object storage with the rows of the
+table's insert journal, which are the rows the tester actually sent
+and got acknowledged, and reports every difference instead of
+stopping at the first. Rows which were dead-lettered or never sent,
+such as those left when -load-duration ends a run, are not in the
+journal and so not expected. The keys, compared columns and extra checks
+come from the table's validation spec. The returned error is only set
+when the comparison itself could not run.
+*/
+func ValidateData(
+	ctx context.Context,
+	logger *slog.Logger,
+	cfg *app.Config,
+	tableRegistry *operations.TableRegistry,
+	manifests *app.ManifestReader,
+	spec app.ValidationSpec,
+	journal *app.InsertJournal,
+	tableName string,
+	deadLetterPath string,
+) (*app.ValidationReport, error) {
+
 	logger.Info(fmt.Sprintf("validating the data consumed by the workers for table %s", tableName))
+	report := app.NewValidationReport(tableName)
+	report.MinRows, report.MaxRows = spec.MinRows, spec.MaxRows
 
-	tmpDir, err := os.MkdirTemp("", "ValidateData")
+	table, err := tableRegistry.GetTable(tableName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// write all of the test data to a temporary directory
-	mem := memory.NewGoAllocator()
-	idx := 0
-	for !dataset.Done() {
-		fp := filepath.Join(tmpDir, fmt.Sprintf("d%d.parquet", idx))
-		rec := dataset.BuildRecord(mem)
+	// the records which were never inserted are not in the journal,
+	// but they are reported
+	report.DeadLetterRows, report.DeadLetterTruncated, err = countDeadLetters(memory.NewGoAllocator(), deadLetterPath)
+	if err != nil {
+		return nil, err
+	}
+	report.UnjournaledRows = journal.LostRows()
+	journalEmpty, err := journal.Empty()
+	if err != nil {
+		return nil, err
+	}
+	report.Time("read expected data")
 
-		forErr := arrowops.WriteRecordToParquetFile(ctx, mem, rec, fp)
-		if forErr != nil {
-			rec.Release()
-			return forErr
-		}
-		rec.Release()
-		idx++
+	// the table is what its manifests reference, not every file in its prefix
+	files, err := manifests.TableFiles(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	report.ManifestPartitions = len(files.Partitions)
+	report.ManifestFiles = len(files.Files)
+	report.OrphanFiles = app.NewFileSample(files.Orphans, validationSampleSize)
+	report.MissingFiles = app.NewFileSample(files.Missing, validationSampleSize)
+	report.Time("read manifests")
+
+	xdb, err := app.OpenWarehouseDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer xdb.Close()
+
+	// nothing was sent and nothing was written
+	if journalEmpty && len(files.Files) == 0 {
+		return report, nil
+	}
+
+	// an empty journal or a table without files has no rows but the
+	// columns of the other side
+	sentRows := fmt.Sprintf("read_parquet('%s')", journal.Pattern())
+	if journalEmpty {
+		sentRows = fmt.Sprintf("(SELECT * FROM %s WHERE false)", files.ReadParquet())
+	}
+	actualRows := "SELECT *, NULL::VARCHAR AS filename FROM expected WHERE false"
+	if len(files.Files) > 0 {
+		actualRows = fmt.Sprintf("SELECT * FROM %s", files.ReadParquet("filename = true"))
+	}
+
+	err = checkNewestRowDefined(ctx, xdb, spec, sentRows)
+	if err != nil {
+		return nil, err
+	}
+
+	// expected holds the newest sent row of each key
+	_, err = xdb.ExecContext(ctx, fmt.Sprintf(`
+CREATE VIEW expected AS
+  SELECT %s FROM (
+    SELECT *, row_number() OVER (PARTITION BY %s %s) AS row_num
+    FROM %s
+  )
+  WHERE row_num = 1;
+CREATE VIEW actual AS
+  %s;
+`,
+		columnList("", append(slices.Clone(spec.KeyColumns), spec.CompareColumns...)),
+		columnList("", spec.KeyColumns), newestFirst(spec),
+		sentRows,
+		actualRows,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	err = compareViews(ctx, xdb, report, spec, spec.FloatColumns(table))
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+
+}
+
+/*
+Fills the report from the expected and actual views. Both hold the
+key and compared columns of the spec; actual also has the filename
+of each row, which gives its partition.
+*/
+func compareViews(
+	ctx context.Context,
+	xdb *sqlx.DB,
+	report *app.ValidationReport,
+	spec app.ValidationSpec,
+	floatColumns map[string]bool,
+) error {
+	err := xdb.QueryRowContext(ctx, `SELECT (SELECT count(*) FROM expected), (SELECT count(*) FROM actual)`).
+		Scan(&report.ExpectedRows, &report.ActualRows)
+	if err != nil {
+		return err
 	}
-	defer os.RemoveAll(tmpDir)
+	report.Time("count rows")
 
-	db, err := sql.Open("duckdb", "")
+	// the key columns are the primary key of the table, so every key
+	// may only appear once
+	keys := columnList("", spec.KeyColumns)
+	err = xdb.GetContext(ctx, &report.DuplicatedKeyCount, fmt.Sprintf(`
+SELECT count(*) FROM (SELECT %[1]s FROM actual GROUP BY %[1]s HAVING count(*) > 1)`, keys))
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-
-	// register the s3 credentials
-	_, err = db.Exec(`
-  INSTALL httpfs;
-  LOAD httpfs;
-  create secret locals3mock3 (
-    TYPE S3,
-    KEY_ID "minioadmin",
-    SECRET "minioadmin",
-    ENDPOINT "pi0:30006",
-    URL_STYLE "path",
-    USE_SSL false
-  );`)
+	err = xdb.SelectContext(ctx, &report.DuplicatedKeys, fmt.Sprintf(`
+SELECT %[1]s AS "key", count(*) AS "count"
+FROM actual
+GROUP BY %[2]s
+HAVING count(*) > 1
+ORDER BY count(*) DESC, "key"
+LIMIT %[3]d`, keyString("actual", spec.KeyColumns), keys, validationSampleSize))
 	if err != nil {
 		return err
 	}
+	report.Time("find duplicated keys")
 
-	xdb := sqlx.NewDb(db, "duckdb")
-	defer xdb.Close()
+	report.MissingKeys, err = sampleKeys(ctx, xdb, spec.KeyColumns, "expected", "actual")
+	if err != nil {
+		return err
+	}
+	report.ExtraKeys, err = sampleKeys(ctx, xdb, spec.KeyColumns, "actual", "expected")
+	if err != nil {
+		return err
+	}
+	report.Time("find missing and extra keys")
 
-	// basic validation for duplicated data
-	// since column1 is the primary key on the table
-	// we should never have more than one row
-	// for each value in column1
-	query := `select count(column1) = 0 as is_valid from(
-      select column1 from 's3://chdb-test-warehouse/chdb/table-state/part-data/%s/*/*.parquet' 
-      group by column1 
-      having count(*) > 1 
-      order by column1
-    );`
-	query = fmt.Sprintf(query, tableName)
-	var dbResp DBValidationResp
-	err = xdb.Get(&dbResp, query)
+	for _, column := range spec.CompareColumns {
+		mismatch := app.ColumnMismatch{Column: column}
+		condition := fmt.Sprintf("NOT (%s)", valuesMatch("e", "a", column, floatColumns[column], spec.FloatTolerance))
+		on := joinColumns("e", "a", spec.KeyColumns, false)
+		err = xdb.GetContext(ctx, &mismatch.Count, fmt.Sprintf(`
+SELECT count(DISTINCT %s) FROM expected e JOIN actual a ON %s WHERE %s`, keyString("e", spec.KeyColumns), on, condition))
+		if err != nil {
+			return err
+		}
+		err = xdb.SelectContext(ctx, &mismatch.Samples, fmt.Sprintf(`
+SELECT
+  %[1]s AS "key",
+  coalesce(CAST(e."%[2]s" AS VARCHAR), 'NULL') AS "expected",
+  coalesce(CAST(a."%[2]s" AS VARCHAR), 'NULL') AS "actual"
+FROM expected e JOIN actual a ON %[3]s
+WHERE %[4]s
+ORDER BY "key"
+LIMIT %[5]d`, keyString("e", spec.KeyColumns), column, on, condition, validationSampleSize))
+		if err != nil {
+			return err
+		}
+		report.ColumnMismatches = append(report.ColumnMismatches, mismatch)
+	}
+	report.Time("compare column values")
+
+	err = xdb.SelectContext(ctx, &report.Partitions, `
+SELECT regexp_extract(filename, '/([^/]+)/[^/]+$', 1) AS "partition", count(*) AS "rows"
+FROM actual
+GROUP BY 1
+ORDER BY 1`)
 	if err != nil {
 		return err
 	}
-	if !dbResp.IsValid {
-		return fmt.Errorf("one or more ids in column1 have been duplicated")
+	report.Time("count partition rows")
+
+	// an assertion which can not run fails instead of stopping the validation
+	for _, assertion := range spec.Assertions {
+		result := app.AssertionResult{Name: assertion.Name}
+		var passed sql.NullBool
+		assertErr := xdb.GetContext(ctx, &passed, assertion.SQL)
+		if assertErr != nil {
+			result.Error = assertErr.Error()
+		} else {
+			result.Passed = passed.Valid && passed.Bool
+		}
+		report.Assertions = append(report.Assertions, result)
+	}
+	if len(spec.Assertions) > 0 {
+		report.Time("run assertions")
 	}
 
-	dataPattern := fmt.Sprintf("%s/*.parquet", tmpDir)
+	return nil
+}
 
-	query = `
-WITH 
-  window_func_rows AS (
-    SELECT 
-      *,
-      row_number() OVER (PARTITION BY column1 ORDER BY sampleId DESC) AS row_num
-    FROM read_parquet('%s')
-  ),
-  mismatched_rows AS (
-    SELECT t1.column1, t1.column2, t1.column3, t2.column2 AS t2_column2, t2.column3 AS t2_column3
-    FROM read_parquet('s3://chdb-test-warehouse/chdb/table-state/part-data/%s/*/*.parquet') t1
-    LEFT JOIN (
-        SELECT
-            column1, column2, column3
-        FROM window_func_rows subquery
-        WHERE row_num = 1
-    ) t2 ON t1.column1 = t2.column1
-    WHERE t2.column1 IS NULL
-          OR t1.column2 != t2.column2 
-          OR t1.column3 != t2.column3
-    )
-SELECT COUNT(*) = 0 AS is_valid, COUNT(*) AS mismatch_count
-FROM mismatched_rows;
-`
-	query = fmt.Sprintf(query, dataPattern, tableName)
+// sampleKeys counts the keys of one view which are not in the other.
+func sampleKeys(ctx context.Context, xdb *sqlx.DB, keyColumns []string, from string, notIn string) (app.KeySample, error) {
+	var sample app.KeySample
+	on := joinColumns("f", "n", keyColumns, false)
+	err := xdb.GetContext(ctx, &sample.Count, fmt.Sprintf(`
+SELECT count(DISTINCT %s) FROM %s f ANTI JOIN %s n ON %s`, keyString("f", keyColumns), from, notIn, on))
+	if err != nil {
+		return sample, err
+	}
+	err = xdb.SelectContext(ctx, &sample.Samples, fmt.Sprintf(`
+SELECT DISTINCT %s FROM %s f ANTI JOIN %s n ON %s
+ORDER BY 1
+LIMIT %d`, keyString("f", keyColumns), from, notIn, on, validationSampleSize))
+	return sample, err
+}
+
+// columnList quotes the columns and joins them with commas, each
+// prefixed by alias unless it is empty.
+func columnList(alias string, columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		if alias == "" {
+			quoted[i] = fmt.Sprintf(`"%s"`, column)
+		} else {
+			quoted[i] = fmt.Sprintf(`%s."%s"`, alias, column)
+		}
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// keyString renders the key columns of a row as one string, joining
+// compound keys with "|".
+func keyString(alias string, keyColumns []string) string {
+	if len(keyColumns) == 1 {
+		return fmt.Sprintf(`CAST(%s."%s" AS VARCHAR)`, alias, keyColumns[0])
+	}
+	casts := make([]string, len(keyColumns))
+	for i, column := range keyColumns {
+		casts[i] = fmt.Sprintf(`CAST(%s."%s" AS VARCHAR)`, alias, column)
+	}
+	return fmt.Sprintf("concat_ws('|', %s)", strings.Join(casts, ", "))
+}
+
+// joinColumns matches the columns of two aliases; nullSafe also
+// matches nulls to each other.
+func joinColumns(left string, right string, columns []string, nullSafe bool) string {
+	operator := "="
+	if nullSafe {
+		operator = "IS NOT DISTINCT FROM"
+	}
+	conditions := make([]string, len(columns))
+	for i, column := range columns {
+		conditions[i] = fmt.Sprintf(`%[1]s."%[3]s" %[4]s %[2]s."%[3]s"`, left, right, column, operator)
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// valuesMatch is true when the column has the same value in both rows,
+// or for float columns a value within the tolerance.
+func valuesMatch(left string, right string, column string, isFloat bool, tolerance float64) string {
+	condition := fmt.Sprintf(`%[1]s."%[3]s" IS NOT DISTINCT FROM %[2]s."%[3]s"`, left, right, column)
+	if isFloat && tolerance > 0 {
+		condition = fmt.Sprintf(
+			`(%[4]s OR coalesce(abs(%[1]s."%[3]s" - %[2]s."%[3]s") <= %[5]v, false))`,
+			left, right, column, condition, tolerance,
+		)
+	}
+	return condition
+}
 
-	var dbMismatchResp DBValidationMismatchResp
-	err = xdb.Get(&dbMismatchResp, query)
+/*
+Fails with ErrAmbiguousNewestRow when the spec has no order column
+and a key has more than one row in rows, since nothing would decide
+which of them is the newest.
+*/
+func checkNewestRowDefined(ctx context.Context, xdb *sqlx.DB, spec app.ValidationSpec, rows string) error {
+	if spec.OrderColumn != "" {
+		return nil
+	}
+	var repeated int64
+	err := xdb.QueryRowContext(ctx, fmt.Sprintf(
+		`SELECT count(*) FROM (SELECT 1 FROM %s GROUP BY %s HAVING count(*) > 1)`,
+		rows, columnList("", spec.KeyColumns),
+	)).Scan(&repeated)
+	if err != nil {
+		return errs.NewStackError(err)
+	}
+	if repeated > 0 {
+		return errs.NewStackError(fmt.Errorf(
+			"%w| %d keys were sent more than once but the validation spec has no orderColumn",
+			app.ErrAmbiguousNewestRow, repeated,
+		))
+	}
+	return nil
+}
+
+// newestFirst orders the rows of a key by the spec's order column.
+func newestFirst(spec app.ValidationSpec) string {
+	if spec.OrderColumn == "" {
+		return ""
+	}
+	return fmt.Sprintf(`ORDER BY "%s" DESC`, spec.OrderColumn)
+}
+
+/*
+Waits until the table state in object storage reflects every record
+in the table's insert journal: for each key of the validation spec
+the newest journaled row must be in the table with matching values in
+every compared column. Keys whose row is still missing or older are
+pending.
+
+The table is read from the files its newest manifests reference, not
+every part file under its prefix, so rows of superseded partition
+versions can't make a key look done before the warehouse published
+the version holding it.
+*/
+func WaitForTable(
+	ctx context.Context,
+	logger *slog.Logger,
+	cfg *app.Config,
+	tableRegistry *operations.TableRegistry,
+	manifests *app.ManifestReader,
+	spec app.ValidationSpec,
+	tableName string,
+	journal *app.InsertJournal,
+	timeout time.Duration,
+	pollInterval time.Duration,
+) error {
+
+	empty, err := journal.Empty()
 	if err != nil {
 		return err
 	}
-	if !dbMismatchResp.IsValid {
-		return fmt.Errorf("the dataset in object storage has %d rows that do not match the expected data", dbMismatchResp.MismatchCount)
+	if empty {
+		return nil
 	}
 
-	return nil
+	table, err := tableRegistry.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+	floatColumns := spec.FloatColumns(table)
+	matches := []string{joinColumns("n", "t", spec.KeyColumns, false)}
+	for _, column := range spec.CompareColumns {
+		matches = append(matches, valuesMatch("n", "t", column, floatColumns[column], spec.FloatTolerance))
+	}
+
+	xdb, err := app.OpenWarehouseDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer xdb.Close()
+
+	err = checkNewestRowDefined(ctx, xdb, spec, fmt.Sprintf("read_parquet('%s')", journal.Pattern()))
+	if err != nil {
+		return err
+	}
+
+	query := `
+WITH
+  newest_rows AS (
+    SELECT
+      *,
+      row_number() OVER (PARTITION BY %[1]s %[2]s) AS row_num
+    FROM read_parquet('%[3]s')
+  )
+SELECT
+  count(DISTINCT %[4]s) FILTER (WHERE t."%[5]s" IS NULL) AS pending,
+  count(DISTINCT %[4]s) AS total
+FROM newest_rows n
+LEFT JOIN %[6]s t
+  ON %[7]s
+WHERE n.row_num = 1;
+`
+
+	// the files of the table change with every batch the workers process
+	return app.WaitForCompletion(ctx, logger, tableName, timeout, pollInterval, func(ctx context.Context) (app.CompletionProgress, error) {
+		var progress app.CompletionProgress
+		files, err := manifests.TableFiles(ctx, tableName)
+		if err != nil {
+			return progress, err
+		}
+		if len(files.Files) == 0 {
+			return progress, errs.NewStackError(fmt.Errorf("%w| table %s", app.ErrTableStateNotFound, tableName))
+		}
+		row := xdb.QueryRowContext(ctx, fmt.Sprintf(
+			query,
+			columnList("", spec.KeyColumns), newestFirst(spec),
+			journal.Pattern(),
+			keyString("n", spec.KeyColumns), spec.KeyColumns[0],
+			files.ReadParquet("filename = true"),
+			strings.Join(matches, " AND "),
+		))
+		err = row.Scan(&progress.Pending, &progress.Total)
+		return progress, err
+	})
 
 }
 
 func IntsertTupleOnInterval(
 	ctx context.Context,
 	logger *slog.Logger,
+	cfg *app.Config,
 	tableRegistry *operations.TableRegistry,
 	interval time.Duration,
-	dataset app.Dataset,
+	retryPolicy app.RetryPolicy,
+	dataset app.StreamingDataset,
 	tableName string,
+	checkpointPath string,
+	deadLetters *app.DeadLetterFile,
+	journal *app.InsertJournal,
 ) {
 
 	keyStorage, err := storage.NewKeyStorage(
 		ctx,
 		logger,
-		storage.KeyStorageOptions{
-			Address:   "chdb-keydb:6379",
-			Password:  "",
-			KeyPrefix: "chapterhouseDB",
-		},
+		cfg.KeyStorageOptions(),
 	)
 	if err != nil {
 		logger.Error("unable to start storage", slog.String("error", errs.ErrorWithStack(err)))
@@ -259,11 +901,7 @@ func IntsertTupleOnInterval(
 	tr, err := operations.BuildTasker(
 		ctx,
 		logger,
-		tasker.Options{
-			KeyDBAddress:  "chdb-keydb:6379",
-			KeyDBPassword: "",
-			KeyPrefix:     "chapterhouseDB",
-		},
+		cfg.TaskerOptions(),
 	)
 	if err != nil {
 		logger.Error("unable to build the tasker", slog.String("error", err.Error()))
@@ -291,21 +929,208 @@ func IntsertTupleOnInterval(
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
-	for !dataset.Done() {
+	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			rec, nextErr := dataset.Next(ctx, mem)
+			if errors.Is(nextErr, io.EOF) {
+				return
+			} else if nextErr != nil {
+				logger.Error("unable to build the next record", slog.String("error", nextErr.Error()))
+				return
+			}
+
 			// Insert Tuple
 			logger.Info("interting tuples")
-			rec := dataset.BuildRecord(mem)
-			insertErr := inserter.InsertTuples(ctx, table.TableName(), sub.SourceName(), rec)
+			insertErr := insertWithRetry(ctx, logger, mem, inserter, table.TableName(), sub.SourceName(), rec, retryPolicy, deadLetters, journal)
+			// prepare for next iteration
+			rec.Release()
+			// a cancelled record is not checkpointed so a restart inserts it
+			if ctx.Err() != nil {
+				return
+			}
 			if insertErr != nil {
 				logger.Error("failed to insert tuple", slog.String("error", insertErr.Error()))
 			}
-			// prepare for next iteration
-			rec.Release()
+
+			// a crash before the checkpoint is saved re-inserts the last
+			// record on restart, which deduplication on sampleId absorbs
+			if checkpointPath != "" {
+				checkpointErr := saveCheckpoint(dataset, checkpointPath)
+				if checkpointErr != nil {
+					logger.Error("failed to save the dataset checkpoint", slog.String("error", checkpointErr.Error()))
+				}
+			}
 		}
 	}
 
 }
+
+/*
+Inserts the records of the dataset with the load generator, giving
+each of its workers an inserter of its own, and logs the achieved
+throughput, insert latencies and error count when the run ends.
+*/
+func InsertTuplesWithLoad(
+	ctx context.Context,
+	logger *slog.Logger,
+	cfg *app.Config,
+	tableRegistry *operations.TableRegistry,
+	profile app.LoadProfile,
+	retryPolicy app.RetryPolicy,
+	dataset app.StreamingDataset,
+	tableName string,
+	deadLetters *app.DeadLetterFile,
+	journal *app.InsertJournal,
+) {
+
+	keyStorage, err := storage.NewKeyStorage(
+		ctx,
+		logger,
+		cfg.KeyStorageOptions(),
+	)
+	if err != nil {
+		logger.Error("unable to start storage", slog.String("error", errs.ErrorWithStack(err)))
+		return
+	}
+	defer keyStorage.Close()
+
+	tr, err := operations.BuildTasker(
+		ctx,
+		logger,
+		cfg.TaskerOptions(),
+	)
+	if err != nil {
+		logger.Error("unable to build the tasker", slog.String("error", err.Error()))
+		return
+	}
+
+	table, err := tableRegistry.GetTable(tableName)
+	if err != nil {
+		logger.Error("unable to find table in registry", slog.String("error", err.Error()))
+		return
+	}
+	sub := table.SubscriptionGroups()[0].Subscriptions()[0]
+
+	mem := memory.NewGoAllocator()
+	inserters := make([]*operations.Inserter, profile.Concurrency)
+	for i := range inserters {
+		inserters[i] = operations.NewInserter(
+			logger,
+			tableRegistry,
+			keyStorage,
+			tr,
+			mem,
+			operations.InserterOptions{
+				PartitionLockDuration: 60 * time.Second,
+			},
+		)
+	}
+
+	logger.Info(
+		"starting the load generator",
+		slog.String("table", tableName),
+		slog.Float64("rowsPerSecond", profile.RowsPerSecond),
+		slog.Int("concurrency", profile.Concurrency),
+	)
+	report, err := app.RunLoad(
+		ctx,
+		logger,
+		dataset,
+		mem,
+		profile,
+		func(ctx context.Context, worker int, rec arrow.Record) error {
+			return insertWithRetry(ctx, logger, mem, inserters[worker], table.TableName(), sub.SourceName(), rec, retryPolicy, deadLetters, journal)
+		},
+	)
+	if err != nil {
+		logger.Error("the load generator stopped early", slog.String("table", tableName), slog.String("error", err.Error()))
+	}
+	if report != nil {
+		logger.Info("load generator report", append([]any{slog.String("table", tableName)}, report.LogAttrs()...)...)
+	}
+
+}
+
+// countDeadLetters returns the number of rows in a dead-letter file and
+// whether the file ends in a truncated record.
+func countDeadLetters(mem *memory.GoAllocator, path string) (int64, bool, error) {
+	records, truncated, err := app.ReadDeadLetterFile(path, mem)
+	if err != nil {
+		return 0, false, err
+	}
+	rows := int64(0)
+	for _, rec := range records {
+		rows += rec.NumRows()
+		rec.Release()
+	}
+	return rows, truncated, nil
+}
+
+/*
+Inserts the record, retrying with backoff while the inserts fail.
+Once every attempt failed, or the error can't be retried, the record
+is appended to the dead-letter file, which validation reads to tell
+the rows that were never inserted apart from rows the warehouse got
+wrong. Inserted records are added to the journal which WaitForTable
+and validation expect; a record the journal fails to take is still
+inserted, so it is not an insert error, but the journal counts its
+rows and validation fails on them.
+*/
+func insertWithRetry(
+	ctx context.Context,
+	logger *slog.Logger,
+	mem *memory.GoAllocator,
+	inserter *operations.Inserter,
+	tableName string,
+	sourceName string,
+	rec arrow.Record,
+	retryPolicy app.RetryPolicy,
+	deadLetters *app.DeadLetterFile,
+	journal *app.InsertJournal,
+) error {
+	err := app.RetryInsert(ctx, logger, retryPolicy, func(ctx context.Context) error {
+		return inserter.InsertTuples(ctx, tableName, sourceName, rec)
+	})
+	if err == nil {
+		app.ObserveTuplesInserted(tableName, sourceName, rec.NumRows())
+		journalErr := journal.Write(ctx, mem, rec)
+		if journalErr != nil {
+			logger.Error(
+				"unable to journal the inserted record; validation will fail",
+				slog.Int64("rows", rec.NumRows()),
+				slog.String("error", journalErr.Error()),
+			)
+		}
+		return nil
+	}
+	// the insert was stopped rather than failed, so its rows are neither
+	// inserted nor dead-lettered
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	deadLetterErr := deadLetters.Write(rec)
+	if deadLetterErr != nil {
+		logger.Error(
+			"unable to write the record to the dead-letter file; its rows are lost",
+			slog.String("path", deadLetters.Path()),
+			slog.String("error", deadLetterErr.Error()),
+		)
+	}
+	return err
+}
+
+func saveCheckpoint(dataset app.StreamingDataset, path string) error {
+	resumable, ok := dataset.(app.ResumableDataset)
+	if !ok {
+		return fmt.Errorf("the dataset %T can not be checkpointed", dataset)
+	}
+	checkpoint, err := resumable.Checkpoint()
+	if err != nil {
+		return err
+	}
+	return app.SaveDatasetCheckpoint(path, checkpoint)
+}
@@ -2,31 +2,101 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/alekLukanen/ChapterhouseDB-example-app/app"
 )
 
 func main() {
 
+	configPath := flag.String("config", os.Getenv(app.ConfigPathEnvVar), "path to a YAML or TOML config file")
+	flag.Parse()
+
 	logger := slog.New(slog.NewJSONHandler(
 		os.Stdout,
 		&slog.HandlerOptions{Level: slog.LevelInfo},
 	))
 	logger.Info("Running ChapterhouseDB Example App")
 
-	ctx := context.Background()
+	cfg, err := app.LoadConfig(*configPath)
+	if err != nil {
+		logger.Error("unable to load the config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// cancel ctx on SIGINT/SIGTERM so the warehouse stops; a second
+	// signal exits immediately
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sig := <-sigCh
+		logger.Info("received signal; shutting down", slog.String("signal", sig.String()))
+		cancel()
 
-	warehouse, err := app.BuildWarehouse(ctx, logger)
+		<-sigCh
+		logger.Error("received a second signal; exiting without draining")
+		os.Exit(2)
+	}()
+
+	health := app.NewHealthChecker(logger, cfg)
+	defer health.Close()
+	if cfg.Health.Address != "" {
+		healthServer := app.StartHTTPServer(logger, "health", cfg.Health.Address, health.Handler())
+		defer healthServer.Close()
+	}
+	if cfg.Metrics.Address != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", app.MetricsHandler())
+		metricsServer := app.StartHTTPServer(logger, "metrics", cfg.Metrics.Address, metricsMux)
+		defer metricsServer.Close()
+	}
+
+	heartbeat := health.Heartbeat()
+	tableRegistry, err := app.BuildTableRegistryWithHeartbeat(ctx, logger, cfg, heartbeat)
+	if err != nil {
+		logger.Error("unable to create the table registry", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	health.SetTableRegistry(tableRegistry)
+
+	warehouse, err := app.BuildWarehouseWithRegistry(ctx, logger, cfg, tableRegistry)
 	if err != nil {
 		logger.Error("warehouse creation failed", slog.String("error", err.Error()))
-		return
+		os.Exit(1)
 	}
 
-	err = warehouse.Run(ctx)
+	// sample the queue while the run loop is active; an empty
+	// queue means the worker is idle rather than stuck
+	sampleCtx, sampleCancel := context.WithCancel(context.Background())
+	defer sampleCancel()
+	go app.SampleQueueLength(
+		sampleCtx,
+		logger,
+		"tuple-processing",
+		cfg.Metrics.QueueSampleInterval,
+		func(ctx context.Context) (float64, error) {
+			ln, err := warehouse.Tasker.QueueLength(ctx, "tuple-processing")
+			if err == nil && ln == 0 {
+				heartbeat.Beat()
+			}
+			return float64(ln), err
+		},
+	)
+
+	err = app.RunWithDrain(ctx, logger, cfg.Worker.DrainTimeout, warehouse.Run)
+	sampleCancel()
 	if err != nil {
 		logger.Error("warehouse run loop failed", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
+	logger.Info("worker stopped")
+
 }